@@ -1,3 +1,9 @@
 module github.com/dbldqt/httpImp
 
 go 1.16
+
+require (
+	github.com/andybalholm/brotli v1.0.5
+	github.com/klauspost/compress v1.15.9
+	golang.org/x/text v0.3.8
+)
@@ -0,0 +1,310 @@
+package httpd
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//单个字段绑定失败的原因
+type FieldError struct {
+	Field  string //结构体中的字段名，嵌套结构体以.连接，如Page.Size
+	Source string //值的来源：query、form、path、header、file、json
+	Key    string //来源中的键名，如query:"page"中的page
+	Err    error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("bind %s: %s %q: %v", e.Field, e.Source, e.Key, e.Err)
+}
+
+//Bind返回的错误，包含所有绑定失败的字段
+type BindErrors []*FieldError
+
+func (es BindErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	durationType    = reflect.TypeOf(time.Duration(0))
+	fileHeaderType  = reflect.TypeOf((*FileHeader)(nil))
+	textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+/**
+Bind根据结构体标签把请求中的数据填充到dst(必须是结构体指针)中：
+	type CreateReq struct {
+		ID      int                `path:"id"`
+		Page    int                `query:"page"`
+		Tags    []string           `query:"tag"`
+		Name    string             `form:"name"`
+		Token   string             `header:"X-Token"`
+		Avatar  *httpd.FileHeader  `file:"avatar"`
+		Since   time.Time          `query:"since" layout:"2006-01-02"`
+		Extra   Pagination         //没有标签的结构体字段会递归绑定
+	}
+Content-Type为application/json(或+json结尾)时，先用encoding/json将body解码到dst中，json标签按encoding/json的规则生效，
+其余标签的值会覆盖json中的同名字段，body超过1MB时返回413的*DecodeError。支持的字段类型有字符串、布尔、整数、浮点数、time.Time(默认RFC 3339)、time.Duration、
+实现了encoding.TextUnmarshaler的类型，以及它们的指针和切片。来源中不存在的键不会修改对应字段。
+绑定失败的字段以BindErrors返回，不会因为一个字段出错而中止其余字段的绑定。
+ */
+func Bind(r *Request, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("httpd: Bind destination must be a non-nil pointer to struct")
+	}
+
+	var errs BindErrors
+	if isJSONMediaType(r.MediaType()) {
+		//与DecodeJSON相同，body最多1MB。超过时不再绑定其余字段，直接返回*DecodeError，WriteError回复413
+		if err := DecodeJSON(r, dst); err != nil {
+			var de *DecodeError
+			if errors.As(err, &de) && de.Status == StatusRequestEntityTooLarge {
+				return err
+			}
+			errs = append(errs, &FieldError{Source: "json", Err: err})
+		}
+	}
+	b := &binder{r: r}
+	b.bindStruct(v.Elem(), "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+type binder struct {
+	r          *Request
+	formParsed bool
+}
+
+func (b *binder) bindStruct(v reflect.Value, prefix string, errs *BindErrors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+		//未导出的字段无法设置，但未导出的嵌入结构体中可能有导出字段
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue
+		}
+		name := prefix + sf.Name
+
+		source, key := "", ""
+		for _, s := range []string{"path", "query", "form", "header", "file"} {
+			if k, ok := sf.Tag.Lookup(s); ok && k != "-" {
+				source, key = s, k
+				break
+			}
+		}
+		if source == "" {
+			b.bindNested(fv, sf, name, errs)
+			continue
+		}
+
+		values, ok := b.lookup(source, key)
+		if !ok {
+			continue
+		}
+		var err error
+		if source == "file" {
+			err = setFiles(fv, values.([]*FileHeader))
+		} else {
+			err = setStrings(fv, values.([]string), sf.Tag.Get("layout"))
+		}
+		if err != nil {
+			*errs = append(*errs, &FieldError{Field: name, Source: source, Key: key, Err: err})
+		}
+	}
+}
+
+//没有绑定标签的字段：结构体(及其指针)递归绑定，其余字段忽略
+func (b *binder) bindNested(fv reflect.Value, sf reflect.StructField, name string, errs *BindErrors) {
+	ft := sf.Type
+	if ft.Kind() == reflect.Ptr {
+		ft = ft.Elem()
+	}
+	if ft.Kind() != reflect.Struct || ft == timeType {
+		return
+	}
+	if sf.Type.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			if !fv.CanSet() {
+				return
+			}
+			//先绑定到临时值上，没有任何字段被设置时保持nil
+			nv := reflect.New(ft)
+			var sub BindErrors
+			b.bindStruct(nv.Elem(), name+".", &sub)
+			*errs = append(*errs, sub...)
+			if !reflect.DeepEqual(nv.Elem().Interface(), reflect.Zero(ft).Interface()) {
+				fv.Set(nv)
+			}
+			return
+		}
+		fv = fv.Elem()
+	}
+	prefix := name + "."
+	if sf.Anonymous {
+		prefix = strings.TrimSuffix(name, sf.Name)
+	}
+	b.bindStruct(fv, prefix, errs)
+}
+
+//取出来源中key对应的值，file返回[]*FileHeader，其余返回[]string
+func (b *binder) lookup(source, key string) (interface{}, bool) {
+	r := b.r
+	switch source {
+	case "path":
+		v, ok := r.pathParams[key]
+		return []string{v}, ok
+	case "query":
+		if r.queryValues == nil {
+			r.queryValues, _ = url.ParseQuery(r.Url.RawQuery)
+		}
+		vs, ok := r.queryValues[key]
+		return vs, ok
+	case "header":
+		vs, ok := r.Header[textproto.CanonicalMIMEHeaderKey(key)]
+		return vs, ok
+	case "form":
+		if !b.parseForm() {
+			return nil, false
+		}
+		if r.multipartForm != nil {
+			vs, ok := r.multipartForm.Value[key]
+			return vs, ok
+		}
+		vs, ok := r.postFormValues[key]
+		return vs, ok
+	case "file":
+		if !b.parseForm() || r.multipartForm == nil {
+			return nil, false
+		}
+		fhs, ok := r.multipartForm.File[key]
+		return fhs, ok && len(fhs) > 0
+	}
+	return nil, false
+}
+
+func (b *binder) parseForm() bool {
+	if !b.formParsed {
+		b.formParsed = true
+		b.r.ParseForm()
+	}
+	return b.r.parseFormErr == nil && b.r.haveParsedForm
+}
+
+func setFiles(fv reflect.Value, fhs []*FileHeader) error {
+	switch {
+	case fv.Type() == fileHeaderType:
+		fv.Set(reflect.ValueOf(fhs[0]))
+	case fv.Kind() == reflect.Slice && fv.Type().Elem() == fileHeaderType:
+		fv.Set(reflect.ValueOf(fhs))
+	default:
+		return fmt.Errorf("unsupported file field type %s", fv.Type())
+	}
+	return nil
+}
+
+//将字符串设置到字段上，切片字段使用全部的值，其余字段使用第一个值
+func setStrings(fv reflect.Value, values []string, layout string) error {
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(fv.Type(), len(values), len(values))
+		for i, s := range values {
+			if err := setString(slice.Index(i), s, layout); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return setString(fv, values[0], layout)
+}
+
+func setString(fv reflect.Value, s string, layout string) error {
+	if fv.Kind() == reflect.Ptr {
+		nv := reflect.New(fv.Type().Elem())
+		if err := setString(nv.Elem(), s, layout); err != nil {
+			return err
+		}
+		fv.Set(nv)
+		return nil
+	}
+	switch fv.Type() {
+	case timeType:
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	//time.Time也实现了TextUnmarshaler，但需要支持layout，所以放在它之后判断
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshaler) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		//复选框未填value时浏览器提交on
+		if s == "on" {
+			s = "true"
+		}
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(v)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
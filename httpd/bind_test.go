@@ -0,0 +1,83 @@
+package httpd
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBindFormRepeatedValues(t *testing.T) {
+	type req struct {
+		Tags  []string `form:"tag"`
+		IDs   []int    `form:"id"`
+		Name  string   `form:"name"`
+		Query []string `query:"q"`
+	}
+	var got req
+	var postForm string
+	var bindErr error
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		bindErr = Bind(r, &got)
+		postForm = r.PostForm("tag")
+	})
+	body := "tag=a&tag=b&id=1&id=2&name=x%20y"
+	raw := "POST /?q=1&q=2 HTTP/1.1\r\nHost: a\r\nContent-Type: application/x-www-form-urlencoded\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	if resp := serveRaw(t, h, raw); !strings.HasPrefix(resp, "HTTP/1.1 200 ") {
+		t.Fatalf("response = %q", resp)
+	}
+	if bindErr != nil {
+		t.Fatal(bindErr)
+	}
+	want := req{Tags: []string{"a", "b"}, IDs: []int{1, 2}, Name: "x y", Query: []string{"1", "2"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	//PostForm对同名字段返回第一个值
+	if postForm != "a" {
+		t.Errorf("PostForm(tag) = %q, want %q", postForm, "a")
+	}
+}
+
+func TestBindJSON(t *testing.T) {
+	type req struct {
+		Name string `json:"name"`
+		Page int    `query:"page"`
+	}
+	var got req
+	r := jsonRequest("application/json", `{"name":"bob"}`)
+	r.Url = &url.URL{RawQuery: "page=2"}
+	if err := Bind(r, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != (req{Name: "bob", Page: 2}) {
+		t.Errorf("got %+v", got)
+	}
+
+	//格式错误的JSON作为字段错误返回，其余字段照常绑定
+	got = req{}
+	r = jsonRequest("application/json", `{"name":`)
+	r.Url = &url.URL{RawQuery: "page=2"}
+	err := Bind(r, &got)
+	bes, ok := err.(BindErrors)
+	if !ok || len(bes) != 1 || bes[0].Source != "json" || got.Page != 2 {
+		t.Errorf("err = %v, got %+v", err, got)
+	}
+
+	//body超过1MB时返回413
+	r = jsonRequest("application/json", `{"name":"`+strings.Repeat("a", 1<<20)+`"}`)
+	r.Url = &url.URL{}
+	err = Bind(r, &got)
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Status != StatusRequestEntityTooLarge {
+		t.Fatalf("err = %v, want 413 *DecodeError", err)
+	}
+	w := newRecorder()
+	WriteError(w, err)
+	if w.code != StatusRequestEntityTooLarge {
+		t.Errorf("WriteError status %d", w.code)
+	}
+}
@@ -0,0 +1,133 @@
+package httpd
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+//CharsetDecoder将某种字符集编码的数据转换为UTF-8，遇到该字符集下的非法字节序列时返回false
+type CharsetDecoder func(b []byte) (string, bool)
+
+var (
+	charsetMu sync.RWMutex
+	charsets  = map[string]CharsetDecoder{
+		"utf-8":        decodeUTF8,
+		"utf8":         decodeUTF8,
+		"us-ascii":     decodeASCII,
+		"ascii":        decodeASCII,
+		"iso-8859-1":   decodeLatin1,
+		"iso8859-1":    decodeLatin1,
+		"latin1":       decodeLatin1,
+		"windows-1252": decodeWindows1252,
+		"cp1252":       decodeWindows1252,
+		//GB2312是GBK的子集，按GBK解码
+		"gbk":          decodeGBK,
+		"gb2312":       decodeGBK,
+		"cp936":        decodeGBK,
+		"gb18030":      decodeGB18030,
+	}
+)
+
+//RegisterCharset注册字符集的解码器，names为字符集的名称及别名，大小写不敏感。
+//内置支持UTF-8、US-ASCII、ISO-8859-1、Windows-1252以及GBK(GB2312)、GB18030，
+//其他字符集可以借助golang.org/x/text/encoding中的实现注册，同名时覆盖内置的解码器
+func RegisterCharset(decoder CharsetDecoder, names ...string) {
+	charsetMu.Lock()
+	defer charsetMu.Unlock()
+	for _, name := range names {
+		charsets[strings.ToLower(name)] = decoder
+	}
+}
+
+func lookupCharset(name string) (CharsetDecoder, bool) {
+	charsetMu.RLock()
+	defer charsetMu.RUnlock()
+	decoder, ok := charsets[strings.ToLower(strings.TrimSpace(name))]
+	return decoder, ok
+}
+
+func decodeUTF8(b []byte) (string, bool) {
+	return string(b), utf8.Valid(b)
+}
+
+func decodeASCII(b []byte) (string, bool) {
+	for _, c := range b {
+		if c >= 0x80 {
+			return "", false
+		}
+	}
+	return string(b), true
+}
+
+//ISO-8859-1的每个字节与Unicode的前256个码点一一对应
+func decodeLatin1(b []byte) (string, bool) {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r), true
+}
+
+//Windows-1252在0x80~0x9F上与ISO-8859-1不同，0x81、0x8D、0x8F、0x90、0x9D未定义
+var windows1252 = [32]rune{
+	0x20AC, 0, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017D, 0,
+	0, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0, 0x017E, 0x0178,
+}
+
+func decodeWindows1252(b []byte) (string, bool) {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		if c < 0x80 || c > 0x9F {
+			r[i] = rune(c)
+			continue
+		}
+		if r[i] = windows1252[c-0x80]; r[i] == 0 {
+			return "", false
+		}
+	}
+	return string(r), true
+}
+
+func decodeGBK(b []byte) (string, bool) {
+	return decodeStrict(simplifiedchinese.GBK, b)
+}
+
+func decodeGB18030(b []byte) (string, bool) {
+	return decodeStrict(simplifiedchinese.GB18030, b)
+}
+
+//x/text的解码器遇到非法字节序列时输出U+FFFD而不是返回错误。
+//输出中含有U+FFFD时再编码回去与原数据比较，GB18030可以合法地编码U+FFFD，不能仅凭它判断
+func decodeStrict(enc encoding.Encoding, b []byte) (string, bool) {
+	s, err := enc.NewDecoder().Bytes(b)
+	if err != nil {
+		return "", false
+	}
+	if bytes.ContainsRune(s, utf8.RuneError) {
+		back, err := enc.NewEncoder().Bytes(s)
+		if err != nil || !bytes.Equal(back, b) {
+			return "", false
+		}
+	}
+	return string(s), true
+}
+
+//表单的字符集未知或表单值不是该字符集下的合法字节序列时返回该错误，服务端会回复400
+type CharsetError struct {
+	Charset string
+	Field   string //出错的字段名，字符集未知时为空
+}
+
+func (e *CharsetError) Error() string {
+	if e.Field == "" {
+		return "httpd: unsupported form charset " + e.Charset
+	}
+	return "httpd: invalid " + e.Charset + " sequence in form field " + e.Field
+}
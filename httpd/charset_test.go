@@ -0,0 +1,63 @@
+package httpd
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCharsetDecoders(t *testing.T) {
+	tests := []struct {
+		charset string
+		in      string
+		want    string
+		ok      bool
+	}{
+		{"utf-8", "中文", "中文", true},
+		{"utf-8", "\xff", "", false},
+		{"us-ascii", "abc", "abc", true},
+		{"us-ascii", "\x80", "", false},
+		{"iso-8859-1", "caf\xe9", "café", true},
+		{"windows-1252", "\x80", "€", true},
+		{"windows-1252", "\x81", "", false},
+		{"GBK", "\xd6\xd0\xce\xc4", "中文", true},
+		{"gb2312", "\xd6\xd0\xce\xc4", "中文", true},
+		{"gbk", "\x81\x20", "", false},
+		{"gbk", "\xd6", "", false},
+		{"gb18030", "\xd6\xd0\xce\xc4", "中文", true},
+		//四字节序列
+		{"gb18030", "\x81\x30\x81\x30", "\u0080", true},
+		//GB18030可以合法地编码U+FFFD
+		{"gb18030", "\x84\x31\xa4\x37", "�", true},
+		{"gb18030", "\x81\x30\x81", "", false},
+		{"gb18030", "\xff", "", false},
+	}
+	for _, tt := range tests {
+		decoder, ok := lookupCharset(tt.charset)
+		if !ok {
+			t.Errorf("charset %q not registered", tt.charset)
+			continue
+		}
+		got, ok := decoder([]byte(tt.in))
+		if ok != tt.ok || ok && got != tt.want {
+			t.Errorf("%s(%q) = %q, %v; want %q, %v", tt.charset, tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFormCharsetGBK(t *testing.T) {
+	var got string
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		got = r.PostForm("name")
+	})
+	body := "name=%D6%D0%CE%C4"
+	raw := "POST / HTTP/1.1\r\nHost: a\r\nContent-Type: application/x-www-form-urlencoded; charset=GBK\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	resp := serveRaw(t, h, raw)
+	if !strings.HasPrefix(resp, "HTTP/1.1 200 ") {
+		t.Fatalf("response = %q", resp)
+	}
+	if got != "中文" {
+		t.Errorf("name = %q, want %q", got, "中文")
+	}
+}
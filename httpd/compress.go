@@ -0,0 +1,250 @@
+package httpd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+//Compressor是响应压缩使用的编码器，compress/gzip、compress/zlib以及github.com/andybalholm/brotli都满足该接口。
+//编码器用完后会被放回池中，下次使用前调用Reset
+type Compressor interface {
+	io.Writer
+	Flush() error
+	Close() error
+	Reset(w io.Writer)
+}
+
+var (
+	compressorMu sync.RWMutex
+	//按服务端的偏好排列，客户端对多个编码的q值相同时选择靠前的
+	compressorOrder []string
+	compressorPools = make(map[string]*sync.Pool)
+)
+
+func init() {
+	RegisterCompressor("deflate", func(w io.Writer) Compressor { return zlib.NewWriter(w) })
+	RegisterCompressor("gzip", func(w io.Writer) Compressor { return gzip.NewWriter(w) })
+	//brotli的压缩率比gzip高，客户端同时接受时优先使用
+	RegisterCompressor("br", func(w io.Writer) Compressor { return brotli.NewWriterLevel(w, brotli.DefaultCompression) })
+}
+
+//RegisterCompressor注册Content-Encoding为encoding的编码器，后注册的编码优先于先注册的，
+//重复注册会替换原有的编码器(例如换一个压缩级别)。内置br、gzip和deflate，按此顺序优先，例如降低brotli的压缩级别以节省CPU：
+//	httpd.RegisterCompressor("br", func(w io.Writer) httpd.Compressor {
+//		return brotli.NewWriterLevel(w, 4)
+//	})
+func RegisterCompressor(encoding string, newCompressor func(w io.Writer) Compressor) {
+	compressorMu.Lock()
+	defer compressorMu.Unlock()
+	encoding = strings.ToLower(encoding)
+	if _, exist := compressorPools[encoding]; !exist {
+		compressorOrder = append([]string{encoding}, compressorOrder...)
+	}
+	compressorPools[encoding] = &sync.Pool{New: func() interface{} {
+		return newCompressor(ioutil.Discard)
+	}}
+}
+
+func getCompressor(encoding string, w io.Writer) Compressor {
+	compressorMu.RLock()
+	pool := compressorPools[encoding]
+	compressorMu.RUnlock()
+	c := pool.Get().(Compressor)
+	c.Reset(w)
+	return c
+}
+
+func putCompressor(encoding string, c Compressor) {
+	compressorMu.RLock()
+	pool := compressorPools[encoding]
+	compressorMu.RUnlock()
+	c.Reset(ioutil.Discard)
+	pool.Put(c)
+}
+
+//Compress的可选配置
+type CompressOption func(cw *compressWriter)
+
+//body小于n字节时不压缩，默认1024。压缩很小的body得不偿失，压缩后甚至可能更大
+func CompressMinSize(n int) CompressOption {
+	return func(cw *compressWriter) {
+		cw.minSize = n
+	}
+}
+
+/**
+Compress根据Accept-Encoding(支持q值)压缩next的响应：
+	mux := httpd.NewServerMux()
+	httpd.ListenAndServe(":8080", httpd.Compress(mux))
+以下响应不压缩：body小于最小长度、已经设置了Content-Encoding、状态码不是2xx或为206、
+图片视频压缩包等本身已经压缩过的类型、HEAD请求。压缩后的响应去掉Content-Length(长度未知，改用chunk编码)和Accept-Ranges，
+强ETag改为弱ETag。handler调用Flush时立即压缩并发送已写入的数据
+*/
+func Compress(next Handler, opts ...CompressOption) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		compressorMu.RLock()
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), compressorOrder)
+		compressorMu.RUnlock()
+		cw := &compressWriter{w: w, r: r, encoding: encoding, minSize: 1024, status: StatusOK}
+		for _, opt := range opts {
+			opt(cw)
+		}
+		next.ServeHTTP(cw, r)
+		cw.finish()
+	})
+}
+
+type compressWriter struct {
+	w        ResponseWriter
+	r        *Request
+	encoding string //协商出的编码，为空表示客户端不接受任何压缩
+	minSize  int
+
+	status      int
+	wroteHeader bool
+	//是否压缩已经确定，确定之前的数据缓存在buf中
+	decided bool
+	c       Compressor
+	buf     bytes.Buffer
+}
+
+func (cw *compressWriter) Header() Header {
+	return cw.w.Header()
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = statusCode
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	cw.wroteHeader = true
+	if cw.decided {
+		if cw.c != nil {
+			return cw.c.Write(p)
+		}
+		return cw.w.Write(p)
+	}
+	cw.buf.Write(p)
+	if cw.buf.Len() >= cw.minSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (cw *compressWriter) Unwrap() ResponseWriter {
+	return cw.w
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		//流式响应的总长度未知，只要类型合适就压缩
+		cw.decide(true)
+	}
+	if cw.c != nil {
+		cw.c.Flush()
+	}
+	if f, ok := cw.w.(Flusher); ok {
+		f.Flush()
+	}
+}
+
+//决定是否压缩，并发送首部和缓存的数据。large表示body已经达到最小长度
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	h := cw.w.Header()
+	//缓存的数据压缩之后chunkWriter就无法再根据它嗅探Content-Type了
+	if h.Get("Content-Type") == "" && cw.buf.Len() > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf.Bytes()))
+	}
+	compressible := cw.compressible()
+	if compressible {
+		addHeaderToken(h, "Vary", "Accept-Encoding")
+	}
+	//HEAD没有body，不压缩，但Vary与GET保持一致
+	if compressible && large && cw.encoding != "" && cw.r.Method != "HEAD" {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.w.WriteHeader(cw.status)
+		cw.c = getCompressor(cw.encoding, cw.w)
+		_, err := cw.c.Write(cw.buf.Bytes())
+		cw.buf.Reset()
+		return err
+	}
+	cw.w.WriteHeader(cw.status)
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	_, err := cw.w.Write(cw.buf.Bytes())
+	cw.buf.Reset()
+	return err
+}
+
+func (cw *compressWriter) compressible() bool {
+	if cw.status < 200 || cw.status >= 300 || cw.status == StatusNoContent || cw.status == StatusPartialContent {
+		return false
+	}
+	h := cw.w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	//handler明确给出的长度小于最小长度
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < cw.minSize {
+		return false
+	}
+	return compressibleType(h.Get("Content-Type"))
+}
+
+//图片、音视频、字体和压缩包等格式本身已经压缩过，再次压缩只会浪费CPU
+func compressibleType(ctype string) bool {
+	if i := strings.IndexByte(ctype, ';'); i != -1 {
+		ctype = ctype[:i]
+	}
+	ctype = strings.ToLower(strings.TrimSpace(ctype))
+	switch ctype {
+	case "image/svg+xml", "image/bmp", "image/x-icon", "image/vnd.microsoft.icon":
+		return true
+	case "application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2", "application/x-xz",
+		"application/x-7z-compressed", "application/vnd.rar", "application/x-rar-compressed", "application/zstd",
+		"application/pdf", "application/octet-stream":
+		return false
+	}
+	for _, prefix := range []string{"image/", "video/", "audio/", "font/woff"} {
+		if strings.HasPrefix(ctype, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cw *compressWriter) finish() {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			return
+		}
+		cw.decide(false)
+	}
+	if cw.c != nil {
+		cw.c.Close()
+		putCompressor(cw.encoding, cw.c)
+		cw.c = nil
+	}
+}
@@ -0,0 +1,102 @@
+package httpd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompressVary(t *testing.T) {
+	text := strings.Repeat("hello world ", 200)
+	handlers := map[string]Handler{
+		//FileServer回复预压缩文件时已经设置了Vary
+		"precompressed": Compress(FileServer(Dir(precompressedDir(t)))),
+		"handler sets Vary": Compress(HandlerFunc(func(w ResponseWriter, r *Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Vary", "accept-encoding")
+			w.Write([]byte(text))
+		})),
+	}
+	for name, h := range handlers {
+		raw := serveRaw(t, h, "GET /a.js HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n")
+		resp, _ := parseResponse(t, raw, "GET")
+		if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("%s: Content-Encoding = %q, want gzip", name, got)
+		}
+		if vary := resp.Header.Values("Vary"); len(vary) != 1 || !strings.EqualFold(vary[0], "Accept-Encoding") {
+			t.Errorf("%s: Vary = %q, want a single Accept-Encoding", name, vary)
+		}
+	}
+}
+
+func TestCompressEncodings(t *testing.T) {
+	text := strings.Repeat("hello world ", 200)
+	h := Compress(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		//分两次写入，中间Flush，流式压缩的数据同样要能完整解码
+		io.WriteString(w, text[:100])
+		w.(Flusher).Flush()
+		io.WriteString(w, text[100:])
+	}))
+	decoders := map[string]func(r io.Reader) (io.Reader, error){
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"br, gzip", "br"},
+		{"gzip, deflate, br", "br"},
+		{"gzip", "gzip"},
+		{"br;q=0.5, gzip", "gzip"},
+	}
+	for _, tt := range tests {
+		raw := serveRaw(t, h, "GET / HTTP/1.1\r\nHost: a\r\nAccept-Encoding: "+tt.accept+"\r\n\r\n")
+		resp, body := parseResponse(t, raw, "GET")
+		encoding := resp.Header.Get("Content-Encoding")
+		if encoding != tt.want {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want %q", tt.accept, encoding, tt.want)
+			continue
+		}
+		zr, err := decoders[encoding](bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ioutil.ReadAll(zr); err != nil || string(got) != text {
+			t.Errorf("%s: decoded %d bytes, err %v", encoding, len(got), err)
+		}
+	}
+}
+
+//HEAD的响应首部与GET一致，即使不压缩也要带Vary: Accept-Encoding
+func TestCompressHeadVary(t *testing.T) {
+	text := strings.Repeat("hello world ", 200)
+	handlers := map[string]Handler{
+		"writes body": Compress(HandlerFunc(func(w ResponseWriter, r *Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(text))
+		})),
+		"status only": Compress(HandlerFunc(func(w ResponseWriter, r *Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(StatusOK)
+		})),
+	}
+	for name, h := range handlers {
+		for _, method := range []string{"GET", "HEAD"} {
+			raw := serveRaw(t, h, method+" / HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n")
+			resp, body := parseResponse(t, raw, method)
+			if vary := resp.Header.Values("Vary"); len(vary) != 1 || vary[0] != "Accept-Encoding" {
+				t.Errorf("%s %s: Vary = %q, want Accept-Encoding", name, method, vary)
+			}
+			if method == "HEAD" && (len(body) != 0 || resp.Header.Get("Content-Encoding") != "") {
+				t.Errorf("%s HEAD: Content-Encoding %q, body %d bytes", name, resp.Header.Get("Content-Encoding"), len(body))
+			}
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package httpd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//FileETag根据文件的大小和修改时间生成强ETag，修改时间精确到纳秒，文件内容变化时ETag随之变化
+func FileETag(size int64, modtime time.Time) string {
+	return `"` + strconv.FormatInt(modtime.UnixNano(), 36) + "-" + strconv.FormatInt(size, 36) + `"`
+}
+
+//ContentETag根据内容的SHA-256生成ETag。weak为true时生成弱ETag，表示内容语义上等价即可，
+//弱ETag不能用于If-Match和If-Range
+func ContentETag(data []byte, weak bool) string {
+	sum := sha256.Sum256(data)
+	tag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+//从s开头取出一个entity-tag，返回它和剩余的部分，格式错误时返回空字符串
+func scanETag(s string) (etag string, remain string) {
+	s = strings.TrimLeft(s, " \t")
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s[start:]) < 2 || s[start] != '"' {
+		return "", ""
+	}
+	//etagc = %x21 / %x23-7E / obs-text
+	for i := start + 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return s[:i+1], s[i+1:]
+		case c == 0x21 || c >= 0x23 && c != 0x7f:
+		default:
+			return "", ""
+		}
+	}
+	return "", ""
+}
+
+//强比较要求两者都不是弱ETag且完全相同，弱比较忽略W/前缀
+func etagStrongMatch(a, b string) bool {
+	return a == b && a != "" && a[0] == '"'
+}
+
+func etagWeakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+//判断If-Match或If-None-Match中的列表是否包含etag，*匹配任何存在的表示
+func matchETags(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return false
+		}
+		var tag string
+		tag, list = scanETag(list)
+		if tag == "" {
+			return false
+		}
+		if etag == "" {
+			continue
+		}
+		if strong && etagStrongMatch(tag, etag) || !strong && etagWeakMatch(tag, etag) {
+			return true
+		}
+	}
+}
+
+//HTTP-date有三种格式，后两种已经废弃但仍须接受
+func parseHTTPTime(s string) (time.Time, error) {
+	var t time.Time
+	var err error
+	for _, layout := range []string{TimeFormat, time.RFC850, time.ANSIC} {
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return t, err
+}
+
+//Last-Modified只精确到秒，比较前需要截断；零值和Unix纪元通常表示修改时间未知
+func isZeroTime(t time.Time) bool {
+	return t.IsZero() || t.Equal(time.Unix(0, 0))
+}
+
+/**
+CheckPreconditions按RFC 9110 13.2.2的顺序处理条件请求，ETag取自w中已设置的ETag首部，modtime为资源的修改时间，零值表示未知：
+	1. If-Match不匹配时回复412；没有If-Match时，If-Unmodified-Since之后有修改也回复412
+	2. If-None-Match匹配时，GET和HEAD回复304，其它方法回复412；
+	   没有If-None-Match时，GET和HEAD请求的If-Modified-Since之后没有修改则回复304
+已经回复时返回true，handler应直接返回。PUT、DELETE等修改资源的handler应在执行修改之前调用，
+以免覆盖其他客户端的修改
+*/
+func CheckPreconditions(w ResponseWriter, r *Request, modtime time.Time) (done bool) {
+	etag := w.Header().Get("ETag")
+	modtime = modtime.Truncate(time.Second)
+	isGetOrHead := r.Method == "GET" || r.Method == "HEAD"
+
+	if im := strings.Join(r.Header["If-Match"], ","); im != "" {
+		if !matchETags(im, etag, true) {
+			serveError(w, StatusPreconditionFailed)
+			return true
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !isZeroTime(modtime) {
+		if t, err := parseHTTPTime(ius); err == nil && modtime.After(t) {
+			serveError(w, StatusPreconditionFailed)
+			return true
+		}
+	}
+
+	if inm := strings.Join(r.Header["If-None-Match"], ","); inm != "" {
+		if matchETags(inm, etag, false) {
+			if isGetOrHead {
+				writeNotModified(w)
+			} else {
+				serveError(w, StatusPreconditionFailed)
+			}
+			return true
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && isGetOrHead && !isZeroTime(modtime) {
+		if t, err := parseHTTPTime(ims); err == nil && !modtime.After(t) {
+			writeNotModified(w)
+			return true
+		}
+	}
+	return false
+}
+
+//304响应只保留ETag、Cache-Control、Vary等首部，去掉描述body的首部
+func writeNotModified(w ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Del("Content-Range")
+	if h.Get("ETag") != "" {
+		h.Del("Last-Modified")
+	}
+	w.WriteHeader(StatusNotModified)
+}
+
+//ConditionalOption是Conditional的可选配置
+type ConditionalOption func(cw *conditionalWriter)
+
+//根据内容生成弱ETag，适用于内容会被压缩等变换的响应
+func WeakETags() ConditionalOption {
+	return func(cw *conditionalWriter) {
+		cw.weak = true
+	}
+}
+
+//超过该大小的响应不再缓存，直接发送，也就不再生成ETag
+const conditionalMaxBuffer = 1 << 20
+
+/**
+Conditional为GET和HEAD请求的200响应处理条件请求：
+	handler设置了ETag或Last-Modified时，在WriteHeader时直接判断，命中则回复304或412，body被丢弃
+	handler都没有设置时，缓存响应的body，根据内容生成ETag后再判断，body超过1MB时放弃
+修改资源的请求在handler执行之后才判断已经没有意义，需要handler自己调用CheckPreconditions
+*/
+func Conditional(next Handler, opts ...ConditionalOption) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &conditionalWriter{w: w, r: r}
+		for _, opt := range opts {
+			opt(cw)
+		}
+		next.ServeHTTP(cw, r)
+		cw.finish()
+	})
+}
+
+type conditionalWriter struct {
+	w    ResponseWriter
+	r    *Request
+	weak bool
+
+	status      int
+	wroteHeader bool
+	//正在缓存body以生成ETag
+	buffering bool
+	//已经回复了304或412，丢弃之后写入的body
+	done bool
+	buf  bytes.Buffer
+}
+
+func (cw *conditionalWriter) Header() Header {
+	return cw.w.Header()
+}
+
+func (cw *conditionalWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = statusCode
+	h := cw.w.Header()
+	switch {
+	case statusCode != StatusOK:
+		cw.w.WriteHeader(statusCode)
+	case h.Get("ETag") != "" || h.Get("Last-Modified") != "":
+		modtime, _ := parseHTTPTime(h.Get("Last-Modified"))
+		if cw.done = CheckPreconditions(cw.w, cw.r, modtime); !cw.done {
+			cw.w.WriteHeader(statusCode)
+		}
+	default:
+		cw.buffering = true
+	}
+}
+
+func (cw *conditionalWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(StatusOK)
+	}
+	if cw.done {
+		return len(p), nil
+	}
+	if !cw.buffering {
+		return cw.w.Write(p)
+	}
+	cw.buf.Write(p)
+	if cw.buf.Len() > conditionalMaxBuffer {
+		cw.buffering = false
+		cw.w.WriteHeader(cw.status)
+		if _, err := cw.w.Write(cw.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		cw.buf.Reset()
+	}
+	return len(p), nil
+}
+
+func (cw *conditionalWriter) Unwrap() ResponseWriter {
+	return cw.w
+}
+
+//流式响应无法生成ETag，Flush时放弃缓存
+func (cw *conditionalWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(StatusOK)
+	}
+	if cw.done {
+		return
+	}
+	if cw.buffering {
+		cw.buffering = false
+		cw.w.WriteHeader(cw.status)
+		cw.w.Write(cw.buf.Bytes())
+		cw.buf.Reset()
+	}
+	if f, ok := cw.w.(Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *conditionalWriter) finish() {
+	if !cw.buffering {
+		return
+	}
+	//HEAD请求的handler通常不写body，此时无法根据内容生成ETag
+	if cw.r.Method == "HEAD" && cw.buf.Len() == 0 {
+		cw.w.WriteHeader(cw.status)
+		return
+	}
+	cw.w.Header().Set("ETag", ContentETag(cw.buf.Bytes(), cw.weak))
+	if CheckPreconditions(cw.w, cw.r, time.Time{}) {
+		return
+	}
+	cw.w.WriteHeader(cw.status)
+	cw.w.Write(cw.buf.Bytes())
+}
@@ -0,0 +1,244 @@
+package httpd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	modtime := time.Date(1994, 11, 6, 8, 49, 37, 0, time.UTC)
+	before := modtime.Add(-time.Hour).Format(TimeFormat)
+	at := modtime.Format(TimeFormat)
+	tests := []struct {
+		name    string
+		method  string
+		etag    string
+		headers []string
+		want    int //0表示条件满足，handler继续处理
+	}{
+		{"no conditions", "GET", `"a"`, nil, 0},
+
+		{"If-Match match", "PUT", `"a"`, []string{"If-Match", `"x", "a"`}, 0},
+		{"If-Match mismatch", "PUT", `"a"`, []string{"If-Match", `"b"`}, StatusPreconditionFailed},
+		{"If-Match mismatch on GET", "GET", `"a"`, []string{"If-Match", `"b"`}, StatusPreconditionFailed},
+		//If-Match使用强比较
+		{"If-Match weak tag", "PUT", `"a"`, []string{"If-Match", `W/"a"`}, StatusPreconditionFailed},
+		{"If-Match weak etag", "PUT", `W/"a"`, []string{"If-Match", `W/"a"`}, StatusPreconditionFailed},
+		{"If-Match * with etag", "PUT", `"a"`, []string{"If-Match", "*"}, 0},
+		{"If-Match no etag", "PUT", "", []string{"If-Match", `"a"`}, StatusPreconditionFailed},
+		{"If-Match list over headers", "PUT", `"a"`, []string{"If-Match", `"x"`, "If-Match", `"a"`}, 0},
+		{"If-Match malformed", "PUT", `"a"`, []string{"If-Match", `a`}, StatusPreconditionFailed},
+		//有If-Match时忽略If-Unmodified-Since
+		{"If-Match overrides IUS", "PUT", `"a"`, []string{"If-Match", `"a"`, "If-Unmodified-Since", before}, 0},
+
+		{"IUS modified", "PUT", "", []string{"If-Unmodified-Since", before}, StatusPreconditionFailed},
+		{"IUS not modified", "PUT", "", []string{"If-Unmodified-Since", at}, 0},
+		{"IUS invalid date", "PUT", "", []string{"If-Unmodified-Since", "yesterday"}, 0},
+
+		{"INM match GET", "GET", `"a"`, []string{"If-None-Match", `"a"`}, StatusNotModified},
+		{"INM match HEAD", "HEAD", `"a"`, []string{"If-None-Match", `"a"`}, StatusNotModified},
+		{"INM match POST", "POST", `"a"`, []string{"If-None-Match", `"a"`}, StatusPreconditionFailed},
+		{"INM * PUT", "PUT", `"a"`, []string{"If-None-Match", "*"}, StatusPreconditionFailed},
+		{"INM mismatch", "GET", `"a"`, []string{"If-None-Match", `"b", "c"`}, 0},
+		//If-None-Match使用弱比较
+		{"INM weak tag", "GET", `"a"`, []string{"If-None-Match", `W/"a"`}, StatusNotModified},
+		{"INM weak etag", "GET", `W/"a"`, []string{"If-None-Match", `"a"`}, StatusNotModified},
+		//有If-None-Match时忽略If-Modified-Since
+		{"INM overrides IMS", "GET", `"a"`, []string{"If-None-Match", `"b"`, "If-Modified-Since", at}, 0},
+		{"INM precedence 304", "GET", `"a"`, []string{"If-None-Match", `"a"`, "If-Modified-Since", before}, StatusNotModified},
+
+		{"IMS not modified", "GET", "", []string{"If-Modified-Since", at}, StatusNotModified},
+		{"IMS modified", "GET", "", []string{"If-Modified-Since", before}, 0},
+		{"IMS on POST", "POST", "", []string{"If-Modified-Since", at}, 0},
+		{"IMS RFC 850", "GET", "", []string{"If-Modified-Since", "Sunday, 06-Nov-94 08:49:37 GMT"}, StatusNotModified},
+		{"IMS asctime", "GET", "", []string{"If-Modified-Since", "Sun Nov  6 08:49:37 1994"}, StatusNotModified},
+		{"IUS RFC 850", "PUT", "", []string{"If-Unmodified-Since", "Sunday, 06-Nov-94 07:49:37 GMT"}, StatusPreconditionFailed},
+		{"IUS asctime", "PUT", "", []string{"If-Unmodified-Since", "Sun Nov  6 07:49:37 1994"}, StatusPreconditionFailed},
+
+		//If-Match先于If-None-Match判断
+		{"If-Match before INM", "GET", `"a"`, []string{"If-Match", `"b"`, "If-None-Match", `"a"`}, StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		w := newRecorder()
+		if tt.etag != "" {
+			w.Header().Set("ETag", tt.etag)
+		}
+		done := CheckPreconditions(w, newTestRequest(tt.method, tt.headers...), modtime.Add(500*time.Millisecond))
+		if done != (tt.want != 0) || w.code != tt.want {
+			t.Errorf("%s: done %v, status %d; want %d", tt.name, done, w.code, tt.want)
+		}
+	}
+}
+
+//修改时间未知时忽略日期条件
+func TestCheckPreconditionsZeroModtime(t *testing.T) {
+	for _, modtime := range []time.Time{{}, time.Unix(0, 0)} {
+		r := newTestRequest("GET", "If-Modified-Since", time.Now().Format(TimeFormat))
+		if CheckPreconditions(newRecorder(), r, modtime) {
+			t.Errorf("modtime %v: If-Modified-Since applied", modtime)
+		}
+		r = newTestRequest("PUT", "If-Unmodified-Since", "Sun, 06 Nov 1994 08:49:37 GMT")
+		if CheckPreconditions(newRecorder(), r, modtime) {
+			t.Errorf("modtime %v: If-Unmodified-Since applied", modtime)
+		}
+	}
+}
+
+//304只保留ETag、Cache-Control、Vary等首部
+func TestNotModifiedHeaders(t *testing.T) {
+	w := newRecorder()
+	h := w.Header()
+	h.Set("ETag", `"a"`)
+	h.Set("Content-Type", "text/plain")
+	h.Set("Content-Length", "10")
+	h.Set("Content-Encoding", "gzip")
+	h.Set("Content-Range", "bytes 0-9/10")
+	h.Set("Last-Modified", "Sun, 06 Nov 1994 08:49:37 GMT")
+	h.Set("Cache-Control", "max-age=60")
+	h.Set("Vary", "Accept-Encoding")
+	if !CheckPreconditions(w, newTestRequest("GET", "If-None-Match", `"a"`), time.Time{}) || w.code != StatusNotModified {
+		t.Fatalf("status %d", w.code)
+	}
+	for _, key := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Content-Range", "Last-Modified"} {
+		if v := h.Get(key); v != "" {
+			t.Errorf("304 kept %s: %q", key, v)
+		}
+	}
+	for _, key := range []string{"ETag", "Cache-Control", "Vary"} {
+		if h.Get(key) == "" {
+			t.Errorf("304 dropped %s", key)
+		}
+	}
+}
+
+func TestScanETag(t *testing.T) {
+	tests := []struct {
+		in, etag, remain string
+	}{
+		{`"abc"`, `"abc"`, ""},
+		{` W/"abc", "d"`, `W/"abc"`, `, "d"`},
+		{`""`, `""`, ""},
+		{`abc`, "", ""},
+		{`"abc`, "", ""},
+		{`"a b"`, "", ""},
+		{`w/"abc"`, "", ""},
+	}
+	for _, tt := range tests {
+		if etag, remain := scanETag(tt.in); etag != tt.etag || remain != tt.remain {
+			t.Errorf("scanETag(%q) = %q, %q; want %q, %q", tt.in, etag, remain, tt.etag, tt.remain)
+		}
+	}
+}
+
+func serveConditional(h Handler, method string, headers ...string) *recorder {
+	w := newRecorder()
+	h.ServeHTTP(w, newTestRequest(method, headers...))
+	return w
+}
+
+func TestConditionalContentETag(t *testing.T) {
+	body := "hello world"
+	handler := HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+	})
+	h := Conditional(handler)
+	w := serveConditional(h, "GET")
+	etag := w.Header().Get("ETag")
+	if w.code != StatusOK || w.body.String() != body || etag != ContentETag([]byte(body), false) {
+		t.Fatalf("status %d, body %q, ETag %q", w.code, w.body.String(), etag)
+	}
+
+	w = serveConditional(h, "GET", "If-None-Match", etag)
+	if w.code != StatusNotModified || w.body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+		t.Errorf("If-None-Match: status %d, body %q, Content-Type %q", w.code, w.body.String(), w.Header().Get("Content-Type"))
+	}
+	w = serveConditional(h, "GET", "If-Match", `"other"`)
+	if w.code != StatusPreconditionFailed {
+		t.Errorf("If-Match: status %d", w.code)
+	}
+
+	weak := Conditional(handler, WeakETags())
+	if w = serveConditional(weak, "GET"); !strings.HasPrefix(w.Header().Get("ETag"), "W/") {
+		t.Errorf("WeakETags: ETag %q", w.Header().Get("ETag"))
+	}
+
+	//修改资源的请求不经过Conditional处理
+	w = serveConditional(h, "POST", "If-None-Match", etag)
+	if w.code != StatusOK || w.Header().Get("ETag") != "" {
+		t.Errorf("POST: status %d, ETag %q", w.code, w.Header().Get("ETag"))
+	}
+}
+
+//handler自己设置了ETag或Last-Modified时在WriteHeader时判断，不缓存body
+func TestConditionalHandlerValidators(t *testing.T) {
+	modtime := "Sun, 06 Nov 1994 08:49:37 GMT"
+	h := Conditional(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Last-Modified", modtime)
+		w.Write([]byte("data"))
+	}))
+	w := serveConditional(h, "GET", "If-Modified-Since", modtime)
+	if w.code != StatusNotModified || w.body.Len() != 0 {
+		t.Errorf("status %d, body %q", w.code, w.body.String())
+	}
+	if w.Header().Get("ETag") != "" {
+		t.Errorf("ETag %q generated although Last-Modified is set", w.Header().Get("ETag"))
+	}
+	if w = serveConditional(h, "GET"); w.code != StatusOK || w.body.String() != "data" {
+		t.Errorf("unconditional: status %d, body %q", w.code, w.body.String())
+	}
+
+	//非200的响应原样发送
+	notFound := Conditional(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.WriteHeader(StatusNotFound)
+		w.Write([]byte("missing"))
+	}))
+	if w = serveConditional(notFound, "GET", "If-None-Match", "*"); w.code != StatusNotFound || w.body.String() != "missing" {
+		t.Errorf("404: status %d, body %q", w.code, w.body.String())
+	}
+}
+
+//body超过1MB或handler调用Flush时放弃缓存，直接流式发送，不再生成ETag
+func TestConditionalStreaming(t *testing.T) {
+	chunk := strings.Repeat("x", 64<<10)
+	large := Conditional(HandlerFunc(func(w ResponseWriter, r *Request) {
+		for i := 0; i < 20; i++ {
+			w.Write([]byte(chunk))
+		}
+	}))
+	w := serveConditional(large, "GET", "If-None-Match", "*")
+	if w.code != StatusOK || w.body.Len() != 20*len(chunk) || w.Header().Get("ETag") != "" {
+		t.Errorf("large: status %d, %d bytes, ETag %q", w.code, w.body.Len(), w.Header().Get("ETag"))
+	}
+
+	var beforeFlush int
+	var rec *recorder
+	flushed := Conditional(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Write([]byte("event 1\n"))
+		w.(Flusher).Flush()
+		beforeFlush = rec.body.Len()
+		w.Write([]byte("event 2\n"))
+	}))
+	rec = newRecorder()
+	flushed.ServeHTTP(rec, newTestRequest("GET"))
+	if beforeFlush != len("event 1\n") {
+		t.Errorf("%d bytes sent at Flush, want %d", beforeFlush, len("event 1\n"))
+	}
+	if rec.code != StatusOK || rec.body.String() != "event 1\nevent 2\n" || rec.Header().Get("ETag") != "" {
+		t.Errorf("flushed: status %d, body %q, ETag %q", rec.code, rec.body.String(), rec.Header().Get("ETag"))
+	}
+}
+
+func TestConditionalHEAD(t *testing.T) {
+	h := Conditional(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(StatusOK)
+	}))
+	//HEAD请求的handler没有写body，无法生成ETag，原样回复200
+	w := serveConditional(h, "HEAD", "If-None-Match", "*")
+	if w.code != StatusOK || w.Header().Get("ETag") != "" {
+		t.Errorf("status %d, ETag %q", w.code, w.Header().Get("ETag"))
+	}
+}
@@ -2,6 +2,7 @@ package httpd
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -47,6 +48,9 @@ func (c *conn)Serve(){
 		}
 
 		resp := c.setupResponse(req)
+		if c.svr.MaxRequestBodySize > 0 {
+			req.Body = newMaxBytesReader(resp,req.Body,c.svr.MaxRequestBodySize)
+		}
 		c.svr.Handler.ServeHTTP(resp,req)
 		if err = req.finishRequest(resp);err != nil{
 			return
@@ -71,4 +75,16 @@ func (c *conn)close(){
 
 func handleErr(err error,c *conn){
 	fmt.Println(err.Error())
+	//报文有歧义时回复400，随后Serve返回关闭连接，不再读取该连接上的任何数据
+	var bre badRequestError
+	if errors.As(err,&bre) {
+		c.writeErrorResponse(StatusBadRequest,bre.Error())
+	}
+}
+
+//此时还没有构造出Request和response，直接将响应报文写入连接
+func (c *conn) writeErrorResponse(statusCode int,msg string) {
+	fmt.Fprintf(c.bufw,"HTTP/1.1 %d %s\r\nConnection: close\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\n\r\n%s",
+		statusCode,statusText[statusCode],len(msg),msg)
+	c.bufw.Flush()
 }
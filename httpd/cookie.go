@@ -0,0 +1,175 @@
+package httpd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Set-Cookie中Expires使用的日期格式，即RFC 7231中的IMF-fixdate
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+type SameSite int
+
+const (
+	SameSiteDefaultMode SameSite = iota //不输出SameSite属性，由浏览器决定(目前多为Lax)
+	SameSiteLaxMode
+	SameSiteStrictMode
+	SameSiteNoneMode //必须同时设置Secure
+)
+
+//Cookie既用于Request.Cookies返回请求中的cookie(只有Name和Value)，也用于SetCookie构造Set-Cookie首部
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time //零值表示不输出Expires
+	//MaxAge=0表示不输出Max-Age，小于0表示立即删除cookie(输出Max-Age=0)，大于0表示cookie的有效秒数
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool //CHIPS分区cookie，必须同时设置Secure
+}
+
+//按RFC 6265检查cookie能否被写入Set-Cookie首部
+func (c *Cookie) Valid() error {
+	if c == nil {
+		return errors.New("httpd: nil Cookie")
+	}
+	if !isCookieName(c.Name) {
+		return errors.New("httpd: invalid Cookie.Name " + strconv.Quote(c.Name))
+	}
+	if !isCookieValue(c.Value) {
+		return errors.New("httpd: invalid Cookie.Value " + strconv.Quote(c.Value))
+	}
+	if !isCookieAttr(c.Path) {
+		return errors.New("httpd: invalid Cookie.Path " + strconv.Quote(c.Path))
+	}
+	if !isCookieDomain(c.Domain) {
+		return errors.New("httpd: invalid Cookie.Domain " + strconv.Quote(c.Domain))
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return errors.New("httpd: invalid Cookie.Expires")
+	}
+	if c.SameSite == SameSiteNoneMode && !c.Secure {
+		return errors.New("httpd: SameSite=None requires Secure")
+	}
+	if c.Partitioned && !c.Secure {
+		return errors.New("httpd: Partitioned requires Secure")
+	}
+	return nil
+}
+
+//String返回Set-Cookie首部的值，调用方应先通过Valid检查
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name + "=")
+	//含有空格或逗号的值需要加上双引号
+	if strings.ContainsAny(c.Value, " ,") {
+		b.WriteString(`"` + c.Value + `"`)
+	} else {
+		b.WriteString(c.Value)
+	}
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		//Domain前面的点没有意义，按RFC 6265去掉
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	switch c.SameSite {
+	case SameSiteLaxMode:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrictMode:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNoneMode:
+		b.WriteString("; SameSite=None")
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+//SetCookie给响应添加一个Set-Cookie首部，多次调用会添加多个首部。cookie不合法时返回错误且不会添加
+func SetCookie(w ResponseWriter, c *Cookie) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	w.Header().Add("Set-Cookie", c.String())
+	return nil
+}
+
+//cookie-name是RFC 2616中的token
+func isCookieName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+//cookie-octet = %x21 / %x23-2B / %x2D-3A / %x3C-5B / %x5D-7E，即除去空白、双引号、逗号、分号和反斜杠的可见ASCII字符。
+//空格和逗号虽然不在其中，但被大量使用，写入时用双引号括起来即可
+func isCookieValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == ' ' || c == ',' {
+			continue
+		}
+		if c < 0x21 || c > 0x7e || c == '"' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+//Path等属性值中不能出现控制字符和分号
+func isCookieAttr(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c == 0x7f || c == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+func isCookieDomain(s string) bool {
+	s = strings.TrimPrefix(s, ".")
+	if len(s) > 255 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '.':
+		//IPv6地址
+		case c == ':' || c == '[' || c == ']':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package httpd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie *Cookie
+		valid  bool
+	}{
+		{"minimal", &Cookie{Name: "a", Value: "1"}, true},
+		{"empty value", &Cookie{Name: "a"}, true},
+		{"space and comma", &Cookie{Name: "a", Value: "x y,z"}, true},
+		{"all attributes", &Cookie{Name: "a", Value: "1", Path: "/app", Domain: ".example.com",
+			Expires: time.Now(), MaxAge: 60, Secure: true, HttpOnly: true, SameSite: SameSiteStrictMode}, true},
+		{"ipv6 domain", &Cookie{Name: "a", Domain: "[::1]"}, true},
+		{"nil", nil, false},
+		{"empty name", &Cookie{Value: "1"}, false},
+		{"name with space", &Cookie{Name: "a b"}, false},
+		{"name with =", &Cookie{Name: "a=b"}, false},
+		{"name with ;", &Cookie{Name: "a;b"}, false},
+		{"value with ;", &Cookie{Name: "a", Value: "1; Domain=evil.com"}, false},
+		{"value with quote", &Cookie{Name: "a", Value: `x"y`}, false},
+		{"value with backslash", &Cookie{Name: "a", Value: `x\y`}, false},
+		{"value with newline", &Cookie{Name: "a", Value: "x\r\nSet-Cookie: b=2"}, false},
+		{"value non-ascii", &Cookie{Name: "a", Value: "é"}, false},
+		{"path with ;", &Cookie{Name: "a", Path: "/; Secure"}, false},
+		{"path with control", &Cookie{Name: "a", Path: "/\n"}, false},
+		{"domain with space", &Cookie{Name: "a", Domain: "example .com"}, false},
+		{"domain with ;", &Cookie{Name: "a", Domain: "example.com;"}, false},
+		{"expires before 1601", &Cookie{Name: "a", Expires: time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC)}, false},
+		{"SameSite=None without Secure", &Cookie{Name: "a", SameSite: SameSiteNoneMode}, false},
+		{"SameSite=None with Secure", &Cookie{Name: "a", SameSite: SameSiteNoneMode, Secure: true}, true},
+		{"Partitioned without Secure", &Cookie{Name: "a", Partitioned: true}, false},
+		{"Partitioned with Secure", &Cookie{Name: "a", Partitioned: true, Secure: true}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.cookie.Valid(); (err == nil) != tt.valid {
+			t.Errorf("%s: Valid() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestCookieString(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CST", 8*3600))
+	tests := []struct {
+		cookie *Cookie
+		want   string
+	}{
+		{&Cookie{Name: "a", Value: "1"}, "a=1"},
+		{&Cookie{Name: "a"}, "a="},
+		{&Cookie{Name: "a", Value: "x y"}, `a="x y"`},
+		{&Cookie{Name: "a", Value: "x,y"}, `a="x,y"`},
+		{&Cookie{Name: "a", Value: "1", Path: "/", Domain: ".example.com"}, "a=1; Path=/; Domain=example.com"},
+		//Expires统一转换为GMT
+		{&Cookie{Name: "a", Value: "1", Expires: expires}, "a=1; Expires=Tue, 01 Jan 2030 19:04:05 GMT"},
+		{&Cookie{Name: "a", Value: "1", MaxAge: 3600}, "a=1; Max-Age=3600"},
+		{&Cookie{Name: "a", Value: "1", MaxAge: -1}, "a=1; Max-Age=0"},
+		{&Cookie{Name: "a", Value: "1", HttpOnly: true, Secure: true}, "a=1; HttpOnly; Secure"},
+		{&Cookie{Name: "a", Value: "1", SameSite: SameSiteLaxMode}, "a=1; SameSite=Lax"},
+		{&Cookie{Name: "a", Value: "1", SameSite: SameSiteStrictMode}, "a=1; SameSite=Strict"},
+		{&Cookie{Name: "a", Value: "1", SameSite: SameSiteNoneMode, Secure: true}, "a=1; Secure; SameSite=None"},
+		{&Cookie{Name: "a", Value: "1", Secure: true, Partitioned: true}, "a=1; Secure; Partitioned"},
+	}
+	for _, tt := range tests {
+		if got := tt.cookie.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestSetCookie(t *testing.T) {
+	w := newRecorder()
+	for _, c := range []*Cookie{
+		{Name: "a", Value: "1"},
+		{Name: "b", Value: "2", Path: "/"},
+		//同名但Path不同的cookie是两个cookie
+		{Name: "a", Value: "3", Path: "/app"},
+	} {
+		if err := SetCookie(w, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"a=1", "b=2; Path=/", "a=3; Path=/app"}
+	if got := w.Header()["Set-Cookie"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Set-Cookie = %q, want %q", got, want)
+	}
+
+	//不合法的cookie不会被添加
+	if err := SetCookie(w, &Cookie{Name: "p", Value: "1", Partitioned: true}); err == nil || !strings.Contains(err.Error(), "Secure") {
+		t.Errorf("Partitioned without Secure: %v", err)
+	}
+	if err := SetCookie(w, &Cookie{Name: "x", Value: "1\r\nLocation: /evil"}); err == nil {
+		t.Error("value with CRLF accepted")
+	}
+	if got := w.Header()["Set-Cookie"]; len(got) != 3 {
+		t.Errorf("invalid cookies added: %q", got)
+	}
+
+	//经过连接发出时每个cookie一个Set-Cookie首部行
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		SetCookie(w, &Cookie{Name: "a", Value: "1"})
+		SetCookie(w, &Cookie{Name: "b", Value: "x,y"})
+	})
+	resp, _ := parseResponse(t, serveRaw(t, h, "GET / HTTP/1.1\r\nHost: a\r\n\r\n"), "GET")
+	if got := resp.Header.Values("Set-Cookie"); !reflect.DeepEqual(got, []string{"a=1", `b="x,y"`}) {
+		t.Errorf("Set-Cookie lines = %q", got)
+	}
+}
+
+func TestRequestCookies(t *testing.T) {
+	r := newTestRequest("GET",
+		"Cookie", `a=1; b="2"; a=3`,
+		"Cookie", "c=4;d=; bad name=5; e; f=x\"y; a=5")
+	var got []string
+	for _, c := range r.Cookies() {
+		got = append(got, c.Name+"="+c.Value)
+	}
+	//同名的cookie按出现顺序全部保留，不合法的cookie被忽略
+	want := []string{"a=1", "b=2", "a=3", "c=4", "d=", "a=5"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Cookies() = %q, want %q", got, want)
+	}
+	if v := r.Cookie("a"); v != "1" {
+		t.Errorf(`Cookie("a") = %q, want the first value`, v)
+	}
+	if v := r.Cookie("missing"); v != "" {
+		t.Errorf(`Cookie("missing") = %q`, v)
+	}
+
+	if cookies := newTestRequest("GET").Cookies(); len(cookies) != 0 {
+		t.Errorf("no Cookie header: %v", cookies)
+	}
+}
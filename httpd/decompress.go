@@ -0,0 +1,224 @@
+package httpd
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+//Decompressor为Content-Encoding创建解码器，r为编码后的body
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+var (
+	decompressorMu sync.RWMutex
+	decompressors  = map[string]Decompressor{
+		"gzip":    newGzipReader,
+		"x-gzip":  newGzipReader,
+		"deflate": newDeflateReader,
+		"br":      newBrotliReader,
+		"zstd":    newZstdReader,
+	}
+)
+
+//RegisterDecompressor注册请求body的解码器，encoding大小写不敏感，同名时替换内置的解码器。
+//内置gzip、deflate、br和zstd
+func RegisterDecompressor(encoding string, d Decompressor) {
+	decompressorMu.Lock()
+	defer decompressorMu.Unlock()
+	decompressors[strings.ToLower(encoding)] = d
+}
+
+func newGzipReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+//HTTP中的deflate是zlib格式，但有些客户端发送的是不带zlib头的原始deflate数据，两种都接受
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	//zlib头：CMF的低4位为8(deflate)，且CMF*256+FLG是31的倍数
+	if head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+func newBrotliReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(brotli.NewReader(r)), nil
+}
+
+//zstd的窗口大小由发送方决定，最大可达数GB，解码时要按窗口大小分配内存。
+//RFC 9659规定HTTP中的zstd窗口不超过8MB，超出的数据按损坏处理
+const zstdMaxWindow = 8 << 20
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+//请求body中的编码数据损坏时，DecompressBody解码出的body返回该错误
+type ContentDecodingError struct {
+	Encoding string
+	Err      error
+}
+
+func (e *ContentDecodingError) Error() string {
+	return "httpd: invalid " + e.Encoding + " request body: " + e.Err.Error()
+}
+
+func (e *ContentDecodingError) Unwrap() error {
+	return e.Err
+}
+
+//记录解码器从下层读到的错误，用来区分是数据损坏还是下层(连接、上一层解码器)出错
+type errRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (er *errRecorder) Read(p []byte) (n int, err error) {
+	n, err = er.r.Read(p)
+	if err != nil {
+		er.err = err
+	}
+	return
+}
+
+//decodeReader把解码器自身产生的错误转换为*ContentDecodingError，并通知response回复400、在响应结束后关闭连接。
+//下层的错误(如连接断开、body超过大小限制)原样返回
+type decodeReader struct {
+	encoding string
+	r        io.Reader
+	src      *errRecorder
+	resp     *response
+	err      error
+}
+
+func (dr *decodeReader) Read(p []byte) (n int, err error) {
+	if dr.err != nil {
+		return 0, dr.err
+	}
+	n, err = dr.r.Read(p)
+	if err == nil || err == io.EOF {
+		return
+	}
+	if src := dr.src.err; src != nil && src != io.EOF && errors.Is(err, src) {
+		return
+	}
+	dr.err = &ContentDecodingError{Encoding: dr.encoding, Err: err}
+	if dr.resp != nil {
+		dr.resp.requestBodyInvalid()
+	}
+	return n, dr.err
+}
+
+//DecompressOption是DecompressBody的可选配置
+type DecompressOption func(d *decompressConfig)
+
+type decompressConfig struct {
+	maxSize int64
+}
+
+//解压后body的最大字节数，默认10MB，n小于等于0表示不限制
+func MaxDecompressedSize(n int64) DecompressOption {
+	return func(d *decompressConfig) {
+		d.maxSize = n
+	}
+}
+
+/**
+DecompressBody根据Content-Encoding透明地解码请求body，handler读到的是解码后的数据：
+	mux.Handle("/ingest", httpd.DecompressBody(ingestHandler, httpd.MaxDecompressedSize(64<<20)))
+Content-Encoding可以有多个，按相反的顺序解码。解码后body的长度与Content-Length不同，
+因此Content-Encoding和Content-Length会从请求首部中删除。
+不支持的编码回复415，并在Accept-Encoding中列出支持的编码；
+编码数据的首部损坏时不调用handler，直接回复400；之后的数据损坏要读到时才能发现，此时handler读body得到*ContentDecodingError，
+handler还未设置状态码时回复400，无论如何都会在响应结束后关闭连接；
+解码后超过上限时回复413，防止几KB的压缩数据解压出几GB的"压缩炸弹"
+*/
+func DecompressBody(next Handler, opts ...DecompressOption) Handler {
+	cfg := decompressConfig{maxSize: 10 << 20}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		var encodings []string
+		for _, v := range r.Header["Content-Encoding"] {
+			for _, e := range strings.Split(v, ",") {
+				if e = strings.ToLower(strings.TrimSpace(e)); e != "" && e != "identity" {
+					encodings = append(encodings, e)
+				}
+			}
+		}
+		if len(encodings) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		decompressorMu.RLock()
+		ds := make([]Decompressor, len(encodings))
+		for i, e := range encodings {
+			ds[i] = decompressors[e]
+		}
+		supported := make([]string, 0, len(decompressors))
+		for e := range decompressors {
+			supported = append(supported, e)
+		}
+		decompressorMu.RUnlock()
+
+		sort.Strings(supported)
+		for _, d := range ds {
+			if d == nil {
+				w.Header().Set("Accept-Encoding", strings.Join(supported, ", "))
+				serveError(w, StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		//handler结束后恢复原来的body，finishRequest需要消费掉的是连接上未读的原始数据
+		orig := r.Body
+		defer func() {
+			r.Body = orig
+		}()
+		body := orig
+		var closers []io.Closer
+		defer func() {
+			for _, c := range closers {
+				c.Close()
+			}
+		}()
+		for i := len(ds) - 1; i >= 0; i-- {
+			src := &errRecorder{r: body}
+			rc, err := ds[i](src)
+			if err != nil {
+				serveError(w, StatusBadRequest)
+				return
+			}
+			closers = append(closers, rc)
+			body = &decodeReader{encoding: encodings[i], r: rc, src: src, resp: r.resp}
+		}
+		if cfg.maxSize > 0 {
+			body = newMaxBytesReader(r.resp, body, cfg.maxSize)
+		}
+		r.Body = body
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		next.ServeHTTP(w, r)
+	})
+}
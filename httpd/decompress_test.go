@@ -0,0 +1,162 @@
+package httpd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func brotliBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	bw := brotli.NewWriter(&buf)
+	bw.Write([]byte(s))
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write([]byte(s))
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+//handler读取body，读取成功时回显内容，失败时把错误记录在bodyErr中且不设置状态码
+func decompressEcho(bodyErr *error) Handler {
+	return DecompressBody(HandlerFunc(func(w ResponseWriter, r *Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if *bodyErr = err; err != nil {
+			return
+		}
+		w.Write(b)
+	}))
+}
+
+func postEncoded(t *testing.T, h Handler, encoding string, body []byte) string {
+	t.Helper()
+	return serveRaw(t, h, "POST / HTTP/1.1\r\nHost: a\r\nContent-Encoding: "+encoding+
+		"\r\nContent-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+string(body))
+}
+
+func TestDecompressBodyEncodings(t *testing.T) {
+	text := strings.Repeat("hello world ", 100)
+	tests := map[string][]byte{
+		"gzip": gzipBytes(t, text),
+		"br":   brotliBytes(t, text),
+		"zstd": zstdBytes(t, text),
+		//多个编码按相反的顺序解码
+		"gzip, zstd": zstdBytes(t, string(gzipBytes(t, text))),
+	}
+	for encoding, body := range tests {
+		var bodyErr error
+		resp, got := parseResponse(t, postEncoded(t, decompressEcho(&bodyErr), encoding, body), "POST")
+		if bodyErr != nil || resp.StatusCode != 200 || string(got) != text {
+			t.Errorf("%s: status %d, err %v, body %q", encoding, resp.StatusCode, bodyErr, got)
+		}
+	}
+}
+
+//数据损坏时handler读到*ContentDecodingError，响应为400并关闭连接
+func TestDecompressBodyCorrupt(t *testing.T) {
+	text := strings.Repeat("hello world ", 1000)
+	gz := gzipBytes(t, text)
+	//破坏gzip首部之后的压缩数据
+	corrupt := append([]byte(nil), gz...)
+	for i := 20; i < len(corrupt)-8; i++ {
+		corrupt[i] ^= 0xff
+	}
+	zs := zstdBytes(t, text)
+	corruptZstd := append([]byte(nil), zs...)
+	for i := len(corruptZstd) / 2; i < len(corruptZstd); i++ {
+		corruptZstd[i] ^= 0x55
+	}
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{"gzip data", "gzip", corrupt},
+		{"gzip truncated", "gzip", gz[:len(gz)/2]},
+		{"zstd data", "zstd", corruptZstd},
+		//窗口超过RFC 9659规定的8MB：Window_Descriptor为0x78(32MB)，后面是一个空的raw block
+		{"zstd window", "zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x78, 0x01, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodyErr error
+			raw := postEncoded(t, decompressEcho(&bodyErr), tt.encoding, tt.body)
+			var cde *ContentDecodingError
+			if !errors.As(bodyErr, &cde) || cde.Encoding != tt.encoding {
+				t.Errorf("body error = %v, want *ContentDecodingError for %s", bodyErr, tt.encoding)
+			}
+			resp, _ := parseResponse(t, raw, "POST")
+			if resp.StatusCode != StatusBadRequest || !resp.Close {
+				t.Errorf("status %d, close %v; want 400 and Connection: close", resp.StatusCode, resp.Close)
+			}
+		})
+	}
+}
+
+func TestDecompressBodyErrors(t *testing.T) {
+	var bodyErr error
+	h := decompressEcho(&bodyErr)
+
+	//gzip首部损坏，不调用handler
+	raw := postEncoded(t, h, "gzip", []byte("not gzip at all"))
+	if resp, _ := parseResponse(t, raw, "POST"); resp.StatusCode != StatusBadRequest {
+		t.Errorf("bad header: status %d, want 400", resp.StatusCode)
+	}
+
+	raw = postEncoded(t, h, "compress", []byte("x"))
+	resp, _ := parseResponse(t, raw, "POST")
+	if resp.StatusCode != StatusUnsupportedMediaType || !strings.Contains(resp.Header.Get("Accept-Encoding"), "zstd") {
+		t.Errorf("unsupported: status %d, Accept-Encoding %q", resp.StatusCode, resp.Header.Get("Accept-Encoding"))
+	}
+
+	//解压炸弹超过上限时仍然是413，不会被当作数据损坏
+	bomb := gzipBytes(t, strings.Repeat("a", 1<<20))
+	limited := DecompressBody(HandlerFunc(func(w ResponseWriter, r *Request) {
+		_, bodyErr = ioutil.ReadAll(r.Body)
+	}), MaxDecompressedSize(1<<10))
+	raw = postEncoded(t, limited, "gzip", bomb)
+	if resp, _ = parseResponse(t, raw, "POST"); resp.StatusCode != StatusRequestEntityTooLarge {
+		t.Errorf("bomb: status %d, want 413", resp.StatusCode)
+	}
+	var mbe *MaxBytesError
+	if !errors.As(bodyErr, &mbe) {
+		t.Errorf("bomb: body error = %v, want *MaxBytesError", bodyErr)
+	}
+}
+
+//下层的错误(如Server.MaxRequestBodySize超限)原样返回，不当作数据损坏
+func TestDecodeReaderSourceError(t *testing.T) {
+	srcErr := &MaxBytesError{Limit: 30}
+	gz := gzipBytes(t, strings.Repeat("hello world ", 1000))
+	src := &errRecorder{r: io.MultiReader(bytes.NewReader(gz[:30]), iotest.ErrReader(srcErr))}
+	rc, err := newGzipReader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(&decodeReader{encoding: "gzip", r: rc, src: src})
+	if err != srcErr {
+		t.Errorf("err = %v, want %v", err, srcErr)
+	}
+}
@@ -0,0 +1,151 @@
+package httpd
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+//摘要算法，取值与RFC 3230/9530中登记的名称一致(小写)
+type DigestAlgorithm string
+
+const (
+	DigestSHA256 DigestAlgorithm = "sha-256"
+	DigestMD5    DigestAlgorithm = "md5"
+	DigestCRC32C DigestAlgorithm = "crc32c"
+)
+
+var ErrDigestMismatch = errors.New("multipart: digest mismatch")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func newHash(alg DigestAlgorithm) hash.Hash {
+	switch alg {
+	case DigestSHA256:
+		return sha256.New()
+	case DigestMD5:
+		return md5.New()
+	case DigestCRC32C:
+		return crc32.New(crc32cTable)
+	}
+	return nil
+}
+
+//digester在part被读取的同时计算摘要，并记录part首部中声明的摘要用于校验
+type digester struct {
+	hashes   map[DigestAlgorithm]hash.Hash
+	expected map[DigestAlgorithm][]byte
+}
+
+//不需要计算任何摘要时返回nil
+func newDigester(algs []DigestAlgorithm, header Header, verify bool) *digester {
+	d := &digester{hashes: make(map[DigestAlgorithm]hash.Hash)}
+	for _, alg := range algs {
+		if h := newHash(alg); h != nil {
+			d.hashes[alg] = h
+		}
+	}
+	if verify {
+		d.expected = expectedDigests(header)
+		for alg := range d.expected {
+			if _, ok := d.hashes[alg]; !ok {
+				d.hashes[alg] = newHash(alg)
+			}
+		}
+	}
+	if len(d.hashes) == 0 {
+		return nil
+	}
+	return d
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	for _, h := range d.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+func (d *digester) sums() map[DigestAlgorithm][]byte {
+	sums := make(map[DigestAlgorithm][]byte, len(d.hashes))
+	for alg, h := range d.hashes {
+		sums[alg] = h.Sum(nil)
+	}
+	return sums
+}
+
+//digestReader把读到的数据交给digester，读到EOF时校验摘要，不一致时返回ErrDigestMismatch而不是io.EOF，
+//这样下游(如FileStore.Put)会把它当作读取失败处理，不会保存未通过校验的内容
+type digestReader struct {
+	r    io.Reader
+	d    *digester
+	sums map[DigestAlgorithm][]byte //读到EOF后计算出的摘要
+	done bool
+	err  error
+}
+
+func (dr *digestReader) Read(p []byte) (n int, err error) {
+	if dr.done {
+		return 0, dr.err
+	}
+	n, err = dr.r.Read(p)
+	dr.d.Write(p[:n])
+	if err == io.EOF {
+		dr.done = true
+		dr.sums = dr.d.sums()
+		if dr.err = dr.d.verify(dr.sums); dr.err != nil {
+			err = dr.err
+		} else {
+			dr.err = io.EOF
+		}
+	}
+	return
+}
+
+func (d *digester) verify(sums map[DigestAlgorithm][]byte) error {
+	for alg, want := range d.expected {
+		if !bytes.Equal(sums[alg], want) {
+			return ErrDigestMismatch
+		}
+	}
+	return nil
+}
+
+//从首部中取出声明的摘要，支持以下写法，值均为base64编码：
+//	Content-MD5: Q2hlY2sgSW50ZWdyaXR5IQ==
+//	Digest: sha-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=,md5=...
+//	Content-Digest: sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:
+//不认识的算法或无法解码的值将被忽略
+func expectedDigests(header Header) map[DigestAlgorithm][]byte {
+	expected := make(map[DigestAlgorithm][]byte)
+	if v := strings.TrimSpace(header.Get("Content-MD5")); v != "" {
+		if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+			expected[DigestMD5] = b
+		}
+	}
+	for _, key := range []string{"Digest", "Content-Digest"} {
+		for _, line := range header[key] {
+			for _, item := range strings.Split(line, ",") {
+				i := strings.IndexByte(item, '=')
+				if i == -1 {
+					continue
+				}
+				alg := DigestAlgorithm(strings.ToLower(strings.TrimSpace(item[:i])))
+				if newHash(alg) == nil {
+					continue
+				}
+				v := strings.Trim(strings.TrimSpace(item[i+1:]), ":")
+				if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+					expected[alg] = b
+				}
+			}
+		}
+	}
+	return expected
+}
@@ -0,0 +1,101 @@
+package httpd
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+//生成一个带Content-MD5首部的文件part，md5Of为计算摘要所用的内容
+func buildDigestForm(t *testing.T, content, md5Of string) (*bytes.Buffer, string) {
+	t.Helper()
+	sum := md5.Sum([]byte(md5Of))
+	var body bytes.Buffer
+	mw := NewMultipartWriter(&body)
+	h := make(Header)
+	h.Set("Content-Disposition", formDataDisposition("file", "a.txt"))
+	h.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(content))
+	mw.Close()
+	return &body, mw.Boundary()
+}
+
+//统计目录下(包括子目录)的文件数
+func countFiles(t *testing.T, dir string) int {
+	t.Helper()
+	n := 0
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, m := range matches {
+		if infos, err := ioutil.ReadDir(m); err == nil {
+			n += len(infos)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+func TestReadFormVerifyDigests(t *testing.T) {
+	type backend struct {
+		name  string
+		opts  func(dir string) *MultipartOptions
+		count func(dir string) int
+	}
+	ms := NewMemStore()
+	backends := []backend{
+		{"memory", func(dir string) *MultipartOptions { return &MultipartOptions{} }, nil},
+		{"temp file", func(dir string) *MultipartOptions { return &MultipartOptions{MaxFileMemory: -1, TempDir: dir} }, nil},
+		{"DirStore", func(dir string) *MultipartOptions { return &MultipartOptions{FileStore: &DirStore{Dir: dir}} }, nil},
+		{"CASStore", func(dir string) *MultipartOptions { return &MultipartOptions{FileStore: &CASStore{Dir: dir}} }, nil},
+		{"MemStore", func(dir string) *MultipartOptions { return &MultipartOptions{FileStore: ms} },
+			func(string) int { return len(ms.objects) }},
+	}
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			dir := t.TempDir()
+			count := func() int {
+				if b.count != nil {
+					return b.count(dir)
+				}
+				return countFiles(t, dir)
+			}
+			opts := b.opts(dir)
+			opts.VerifyDigests = true
+
+			//内容与Content-MD5不一致，后端中不能留下文件
+			body, boundary := buildDigestForm(t, "tampered", "original")
+			if _, err := NewMultipartReader(body, boundary).ReadForm(opts); err != ErrDigestMismatch {
+				t.Fatalf("err = %v, want %v", err, ErrDigestMismatch)
+			}
+			if n := count(); n != 0 {
+				t.Errorf("%d files left after digest mismatch", n)
+			}
+
+			body, boundary = buildDigestForm(t, "original", "original")
+			form, err := NewMultipartReader(body, boundary).ReadForm(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer form.RemoveAll()
+			fh := form.File["file"][0]
+			if sum := md5.Sum([]byte("original")); !bytes.Equal(fh.Digests[DigestMD5], sum[:]) {
+				t.Errorf("md5 = %x, want %x", fh.Digests[DigestMD5], sum)
+			}
+			rc, err := fh.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			if got, _ := ioutil.ReadAll(rc); string(got) != "original" {
+				t.Errorf("content = %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package httpd
+
+import (
+	"strconv"
+	"strings"
+)
+
+/**
+negotiateEncoding根据Accept-Encoding从offers中选出客户端最希望的编码，q值相同时按offers中的顺序，
+即服务端的偏好。没有可接受的编码时返回空字符串，表示使用原始内容：
+	Accept-Encoding: gzip;q=0.8, br         选择br
+	Accept-Encoding: *;q=0.5, gzip;q=0      *匹配未列出的编码，gzip被明确拒绝
+*/
+func negotiateEncoding(accept string, offers []string) string {
+	if accept == "" {
+		return ""
+	}
+	qs := make(map[string]float64)
+	for _, item := range strings.Split(accept, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		coding, q := item, 1.0
+		if i := strings.IndexByte(item, ';'); i != -1 {
+			coding = strings.TrimSpace(item[:i])
+			for _, param := range strings.Split(item[i+1:], ";") {
+				param = strings.TrimSpace(param)
+				if len(param) > 2 && (param[0] == 'q' || param[0] == 'Q') && param[1] == '=' {
+					v, err := strconv.ParseFloat(param[2:], 64)
+					if err != nil || v < 0 || v > 1 {
+						v = 0
+					}
+					q = v
+				}
+			}
+		}
+		qs[strings.ToLower(coding)] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, ok := qs[offer]
+		if !ok {
+			//x-gzip是gzip的别名
+			if offer == "gzip" {
+				q, ok = qs["x-gzip"]
+			}
+			if !ok {
+				q = qs["*"]
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,212 @@
+package httpd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//ReadForm默认将文件缓存在内存或暂存文件中，handler再从中拷贝到最终的位置，大文件上传时相当于多了一次I/O。
+//FileStore让文件part在读取的同时直接写入最终的存储后端，FileHeader.Open再从后端读回。
+type FileStore interface {
+	//Put将r中的文件内容全部写入后端，返回之后用于Open的key。
+	//出错时(包括r返回的错误)不应在后端留下不完整的文件
+	Put(fh *FileHeader, r io.Reader) (key string, err error)
+	Open(key string) (io.ReadCloser, error)
+}
+
+//支持删除的存储后端可以实现该接口。ReadForm出错(如超过大小限制)时会调用Remove删除本次请求已经写入的文件，
+//避免在后端留下孤立的对象。ReadForm成功后文件归handler所有，MultipartForm.RemoveAll不会删除存储后端中的文件
+type FileRemover interface {
+	Remove(key string) error
+}
+
+var errInvalidKey = errors.New("filestore: invalid key")
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (n int, err error) {
+	n, err = cr.r.Read(p)
+	cr.n += int64(n)
+	return
+}
+
+//DirStore将文件保存到本地目录Dir下，文件名随机生成，请求结束后不会删除
+type DirStore struct {
+	Dir string
+}
+
+func (ds *DirStore) Put(fh *FileHeader, r io.Reader) (key string, err error) {
+	//保留原文件的扩展名，方便直接在目录中查看
+	file, err := os.CreateTemp(ds.Dir, "upload-*"+filepath.Ext(filepath.Base(fh.Filename)))
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(file, r)
+	if cerr := file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return filepath.Base(file.Name()), nil
+}
+
+func (ds *DirStore) Open(key string) (io.ReadCloser, error) {
+	path, err := ds.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+//删除key对应的文件，文件不存在时返回nil
+func (ds *DirStore) Remove(key string) error {
+	path, err := ds.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//key只能是DirStore自己生成的文件名，防止借助"../"读取或删除目录之外的文件
+func (ds *DirStore) path(key string) (string, error) {
+	if key == "" || key != filepath.Base(key) || strings.HasPrefix(key, ".") || strings.ContainsAny(key, `/\`) {
+		return "", errInvalidKey
+	}
+	return filepath.Join(ds.Dir, key), nil
+}
+
+//MemStore是一个内存中的对象存储，行为与S3这类对象存储一致：对象以key寻址，写入完成前不可见。
+//可以在测试或开发环境中替代真正的对象存储
+type MemStore struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{objects: make(map[string][]byte)}
+}
+
+func (ms *MemStore) Put(fh *FileHeader, r io.Reader) (key string, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	var b [16]byte
+	if _, err = rand.Read(b[:]); err != nil {
+		return
+	}
+	key = hex.EncodeToString(b[:]) + "/" + filepath.Base(fh.Filename)
+	ms.mu.Lock()
+	ms.objects[key] = data
+	ms.mu.Unlock()
+	return key, nil
+}
+
+func (ms *MemStore) Open(key string) (io.ReadCloser, error) {
+	ms.mu.RLock()
+	data, ok := ms.objects[key]
+	ms.mu.RUnlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+//删除对象，与S3的DeleteObject对应，对象不存在时同样返回nil
+func (ms *MemStore) Remove(key string) error {
+	ms.mu.Lock()
+	delete(ms.objects, key)
+	ms.mu.Unlock()
+	return nil
+}
+
+//CASStore按内容寻址保存文件：key是文件内容的sha256，文件保存在Dir/key[:2]/key。
+//内容相同的文件只保存一份
+type CASStore struct {
+	Dir string
+}
+
+func (cs *CASStore) Put(fh *FileHeader, r io.Reader) (key string, err error) {
+	//写完之前不知道内容的hash，先写入Dir下的暂存文件，再重命名
+	file, err := os.CreateTemp(cs.Dir, ".cas-")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, h), r)
+	if cerr := file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+
+	key = hex.EncodeToString(h.Sum(nil))
+	path, _ := cs.path(key)
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	//已经存在相同内容的文件，丢弃本次写入的副本
+	if _, serr := os.Stat(path); serr == nil {
+		os.Remove(file.Name())
+		return key, nil
+	}
+	if err = os.Rename(file.Name(), path); err != nil {
+		return
+	}
+	return key, nil
+}
+
+func (cs *CASStore) Open(key string) (io.ReadCloser, error) {
+	path, err := cs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+//删除key对应的文件，文件不存在时返回nil。内容相同的文件只保存一份，
+//因此删除会同时影响其它引用同一key的上传
+func (cs *CASStore) Remove(key string) error {
+	path, err := cs.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//key只能是64个字符的十六进制sha256
+func (cs *CASStore) path(key string) (string, error) {
+	if len(key) != sha256.Size*2 {
+		return "", errInvalidKey
+	}
+	if _, err := hex.DecodeString(key); err != nil {
+		return "", errInvalidKey
+	}
+	return filepath.Join(cs.Dir, key[:2], key), nil
+}
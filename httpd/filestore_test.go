@@ -0,0 +1,199 @@
+package httpd
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	_ FileRemover = (*MemStore)(nil)
+	_ FileRemover = (*DirStore)(nil)
+	_ FileRemover = (*CASStore)(nil)
+)
+
+func buildFileForm(t *testing.T, files ...string) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := NewMultipartWriter(&body)
+	for i, content := range files {
+		w, err := mw.CreateFormFile("file", string(rune('a'+i))+".txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, mw.Boundary()
+}
+
+func TestReadFormFileStoreTotalSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		wantErr bool
+	}{
+		{"within limit", []string{"abc", "d"}, false},
+		{"exact limit then empty file", []string{"abcd", ""}, false},
+		//第一个文件恰好用完额度，剩余额度为0时不能被当作不限制
+		{"exact limit then more", []string{"abcd", "e"}, true},
+		{"over limit", []string{"abcde"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := NewMemStore()
+			body, boundary := buildFileForm(t, tt.files...)
+			form, err := NewMultipartReader(body, boundary).ReadForm(&MultipartOptions{FileStore: ms, MaxTotalSize: 4})
+			if tt.wantErr {
+				if err != ErrMessageTooLarge {
+					t.Fatalf("err = %v, want %v", err, ErrMessageTooLarge)
+				}
+				//出错时已经写入的对象要被删除
+				if len(ms.objects) != 0 {
+					t.Errorf("%d objects left in store", len(ms.objects))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(form.File["file"]) != len(tt.files) {
+				t.Fatalf("got %d files, want %d", len(form.File["file"]), len(tt.files))
+			}
+			//ReadForm成功后文件归handler所有，RemoveAll不删除存储后端中的对象
+			form.RemoveAll()
+			if len(ms.objects) != len(tt.files) {
+				t.Errorf("%d objects in store, want %d", len(ms.objects), len(tt.files))
+			}
+		})
+	}
+}
+
+//ReadForm失败时，之前已经写入磁盘存储的文件都要被删除
+func TestReadFormDiskStoreCleanup(t *testing.T) {
+	//第一个文件正常，第二个文件的Content-MD5与内容不一致
+	digestForm := func(t *testing.T) (*bytes.Buffer, string) {
+		var body bytes.Buffer
+		mw := NewMultipartWriter(&body)
+		w, _ := mw.CreateFormFile("file", "a.txt")
+		w.Write([]byte("good"))
+		sum := md5.Sum([]byte("original"))
+		h := make(Header)
+		h.Set("Content-Disposition", formDataDisposition("file", "b.txt"))
+		h.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		w, _ = mw.CreatePart(h)
+		w.Write([]byte("tampered"))
+		mw.Close()
+		return &body, mw.Boundary()
+	}
+	tests := []struct {
+		name string
+		form func(t *testing.T) (*bytes.Buffer, string)
+		opts MultipartOptions
+		err  error
+	}{
+		{"MaxParts", func(t *testing.T) (*bytes.Buffer, string) { return buildFileForm(t, "a", "b", "c") },
+			MultipartOptions{MaxParts: 2}, ErrMessageTooLarge},
+		{"MaxTotalSize", func(t *testing.T) (*bytes.Buffer, string) { return buildFileForm(t, "abc", "def") },
+			MultipartOptions{MaxTotalSize: 4}, ErrMessageTooLarge},
+		{"digest mismatch", digestForm, MultipartOptions{VerifyDigests: true}, ErrDigestMismatch},
+	}
+	stores := map[string]func(dir string) FileStore{
+		"DirStore": func(dir string) FileStore { return &DirStore{Dir: dir} },
+		"CASStore": func(dir string) FileStore { return &CASStore{Dir: dir} },
+	}
+	for storeName, newStore := range stores {
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				opts := tt.opts
+				opts.FileStore = newStore(dir)
+				body, boundary := tt.form(t)
+				if _, err := NewMultipartReader(body, boundary).ReadForm(&opts); err != tt.err {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				if n := countFiles(t, dir); n != 0 {
+					t.Errorf("%d files left in %s", n, dir)
+				}
+			})
+		}
+	}
+}
+
+//Remove与Open一样只接受存储自己生成的key，不能删除目录之外的文件
+func TestFileStoreRemoveKey(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "store")
+	os.Mkdir(dir, 0755)
+	victim := filepath.Join(root, "victim")
+	os.WriteFile(victim, []byte("x"), 0644)
+	hidden := filepath.Join(dir, ".hidden")
+	os.WriteFile(hidden, []byte("x"), 0644)
+
+	ds, cs := &DirStore{Dir: dir}, &CASStore{Dir: dir}
+	for _, key := range []string{"", "../victim", "..", ".hidden", "a/../../victim", `..\victim`} {
+		if err := ds.Remove(key); err != errInvalidKey {
+			t.Errorf("DirStore.Remove(%q) = %v", key, err)
+		}
+	}
+	for _, key := range []string{"", "../victim", strings.Repeat("../", 21) + "v", strings.Repeat("g", 64)} {
+		if err := cs.Remove(key); err != errInvalidKey {
+			t.Errorf("CASStore.Remove(%q) = %v", key, err)
+		}
+	}
+	for _, file := range []string{victim, hidden} {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("%s removed: %v", file, err)
+		}
+	}
+
+	for name, store := range map[string]FileStore{"DirStore": ds, "CASStore": cs} {
+		key, err := store.Put(&FileHeader{Filename: "a.txt"}, strings.NewReader("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fr := store.(FileRemover)
+		if err = fr.Remove(key); err != nil {
+			t.Errorf("%s: Remove: %v", name, err)
+		}
+		if _, err = store.Open(key); !os.IsNotExist(err) {
+			t.Errorf("%s: Open after Remove: %v", name, err)
+		}
+		//删除不存在的对象不是错误
+		if err = fr.Remove(key); err != nil {
+			t.Errorf("%s: second Remove: %v", name, err)
+		}
+	}
+}
+
+//包装了读取错误的存储后端
+type wrappingStore struct{}
+
+func (wrappingStore) Put(fh *FileHeader, r io.Reader) (string, error) {
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return "", fmt.Errorf("upload %s: %w", fh.Filename, err)
+	}
+	return "key", nil
+}
+
+func (wrappingStore) Open(key string) (io.ReadCloser, error) {
+	return nil, os.ErrNotExist
+}
+
+//超过MaxTotalSize的错误被存储后端包装后仍然是ErrMessageTooLarge
+func TestReadFormFileStoreWrappedError(t *testing.T) {
+	body, boundary := buildFileForm(t, "abcdef")
+	_, err := NewMultipartReader(body, boundary).ReadForm(&MultipartOptions{FileStore: wrappingStore{}, MaxTotalSize: 4})
+	if err != ErrMessageTooLarge {
+		t.Errorf("err = %v, want %v", err, ErrMessageTooLarge)
+	}
+}
@@ -0,0 +1,432 @@
+package httpd
+
+import (
+	"errors"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//FileSystem是FileServer访问文件的抽象，name为以/分隔的绝对路径，如/css/app.css
+type FileSystem interface {
+	Open(name string) (File, error)
+}
+
+//File是FileSystem.Open返回的文件，目录需要支持Readdir
+type File interface {
+	io.Closer
+	io.Reader
+	io.Seeker
+	Readdir(count int) ([]fs.FileInfo, error)
+	Stat() (fs.FileInfo, error)
+}
+
+//Dir以本地目录作为FileSystem，name中的..最多只能回到Dir本身，无法访问Dir之外的文件
+type Dir string
+
+func (d Dir) Open(name string) (File, error) {
+	//name使用/分隔，Windows下出现\可能被当作路径分隔符绕过检查
+	if filepath.Separator != '/' && strings.ContainsRune(name, filepath.Separator) {
+		return nil, errors.New("httpd: invalid character in file path")
+	}
+	dir := string(d)
+	if dir == "" {
+		dir = "."
+	}
+	fullName := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name)))
+	f, err := os.Open(fullName)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+//FS将fs.FS(如embed.FS)转换为FileSystem，fsys中的文件需要实现io.Seeker，目录需要实现fs.ReadDirFile
+func FS(fsys fs.FS) FileSystem {
+	return ioFS{fsys}
+}
+
+type ioFS struct {
+	fsys fs.FS
+}
+
+func (f ioFS) Open(name string) (File, error) {
+	//fs.FS的路径不以/开头，根目录为.
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioFile{file}, nil
+}
+
+type ioFile struct {
+	fs.File
+}
+
+func (f ioFile) Seek(offset int64, whence int) (int64, error) {
+	s, ok := f.File.(io.Seeker)
+	if !ok {
+		return 0, errors.New("httpd: file does not implement io.Seeker")
+	}
+	return s.Seek(offset, whence)
+}
+
+func (f ioFile) Readdir(count int) ([]fs.FileInfo, error) {
+	d, ok := f.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, errors.New("httpd: file does not implement fs.ReadDirFile")
+	}
+	entries, err := d.ReadDir(count)
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, ierr := e.Info()
+		if ierr != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, err
+}
+
+type fileHandler struct {
+	root     FileSystem
+	listDirs bool
+}
+
+//FileServer的可选配置
+type FileServerOption func(h *fileHandler)
+
+//目录中没有index.html时列出目录内容，默认回复403
+func DirectoryListing() FileServerOption {
+	return func(h *fileHandler) {
+		h.listDirs = true
+	}
+}
+
+/**
+FileServer返回以root为根目录提供静态文件的Handler，请求路径直接映射为root中的路径。
+ServeMux按完整路径匹配路由，mux.Handle("/", ...)只能匹配"/"本身，因此FileServer应直接作为Server.Handler使用：
+	httpd.ListenAndServe(":8080", httpd.FileServer(httpd.Dir("./public")))
+	//go:embed static
+	var static embed.FS
+	httpd.ListenAndServe(":8080", httpd.FileServer(httpd.FS(static)))
+与ServeMux一起使用时，在外层按前缀分发，其余请求交给ServeMux：
+	files := httpd.FileServer(httpd.Dir("./public"))
+	httpd.ListenAndServe(":8080", httpd.HandlerFunc(func(w httpd.ResponseWriter, r *httpd.Request) {
+		if strings.HasPrefix(r.Url.Path, "/api/") {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+请求目录时返回其中的index.html，目录路径不以/结尾时重定向到以/结尾的路径，以保证页面中的相对链接正确。
+*/
+func FileServer(root FileSystem, opts ...FileServerOption) Handler {
+	h := &fileHandler{root: root}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *fileHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		serveError(w, StatusMethodNotAllowed)
+		return
+	}
+	upath := r.Url.Path
+	if !strings.HasPrefix(upath, "/") {
+		upath = "/" + upath
+	}
+	if containsDotDot(upath) {
+		serveError(w, StatusBadRequest)
+		return
+	}
+	serveFile(w, r, h.root, path.Clean(upath), true, h.listDirs)
+}
+
+//ServeFile回复本地文件或目录name的内容。请求路径中含有..时拒绝处理，
+//因为调用方很可能是用请求路径拼出的name
+func ServeFile(w ResponseWriter, r *Request, name string) {
+	if containsDotDot(r.Url.Path) {
+		serveError(w, StatusBadRequest)
+		return
+	}
+	dir, file := filepath.Split(name)
+	serveFile(w, r, Dir(dir), file, false, false)
+}
+
+func containsDotDot(p string) bool {
+	if !strings.Contains(p, "..") {
+		return false
+	}
+	for _, seg := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if seg == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+func serveFile(w ResponseWriter, r *Request, fsys FileSystem, name string, redirect bool, listDirs bool) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		serveError(w, errorStatus(err))
+		return
+	}
+	defer f.Close()
+	d, err := f.Stat()
+	if err != nil {
+		serveError(w, errorStatus(err))
+		return
+	}
+
+	if redirect {
+		url := r.Url.Path
+		if d.IsDir() && !strings.HasSuffix(url, "/") {
+			localRedirect(w, r, path.Base(url)+"/")
+			return
+		}
+		if !d.IsDir() && strings.HasSuffix(url, "/") && url != "/" {
+			localRedirect(w, r, "../"+path.Base(url))
+			return
+		}
+	}
+
+	if d.IsDir() {
+		index := strings.TrimSuffix(name, "/") + "/index.html"
+		if ff, err := fsys.Open(index); err == nil {
+			defer ff.Close()
+			if dd, err := ff.Stat(); err == nil && !dd.IsDir() {
+				f, d, name = ff, dd, index
+			}
+		}
+	}
+	if d.IsDir() {
+		if !listDirs {
+			serveError(w, StatusForbidden)
+			return
+		}
+		dirList(w, r, f)
+		return
+	}
+	encoding := ""
+	if w.Header().Get("Content-Encoding") == "" {
+		var cf File
+		var cd fs.FileInfo
+		if cf, cd, encoding = openPrecompressed(w, r, fsys, name); cf != nil {
+			defer cf.Close()
+			//Content-Type取原始文件的，而不是.gz、.br文件的
+			ctype, err := contentType(d.Name(), f)
+			if err != nil {
+				serveError(w, StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", ctype)
+			w.Header().Set("Content-Encoding", encoding)
+			f, d = cf, cd
+		}
+	}
+	//不同的编码是不同的表示，ETag中加上编码，避免大小和修改时间恰好相同时ETag冲突
+	if w.Header().Get("ETag") == "" {
+		etag := FileETag(d.Size(), d.ModTime())
+		if encoding != "" {
+			etag = strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+		}
+		w.Header().Set("ETag", etag)
+	}
+	ServeContent(w, r, d.Name(), d.ModTime(), f)
+}
+
+//构建工具生成的预压缩文件，按服务端的偏好排列
+var precompressedExts = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+//查找name的预压缩版本，如app.js.br、app.js.gz，根据Accept-Encoding选择其中一个打开。
+//只要存在预压缩版本，响应就会因Accept-Encoding而不同，需要设置Vary，即使最终回复的是原始文件
+func openPrecompressed(w ResponseWriter, r *Request, fsys FileSystem, name string) (File, fs.FileInfo, string) {
+	var offers []string
+	files := make(map[string]File)
+	infos := make(map[string]fs.FileInfo)
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	for _, pc := range precompressedExts {
+		f, err := fsys.Open(name + pc.ext)
+		if err != nil {
+			continue
+		}
+		files[pc.encoding] = f
+		d, err := f.Stat()
+		if err != nil || d.IsDir() {
+			continue
+		}
+		infos[pc.encoding] = d
+		offers = append(offers, pc.encoding)
+	}
+	if len(offers) == 0 {
+		return nil, nil, ""
+	}
+	addHeaderToken(w.Header(), "Vary", "Accept-Encoding")
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), offers)
+	if encoding == "" {
+		return nil, nil, ""
+	}
+	f := files[encoding]
+	//选中的文件由调用方关闭
+	files[encoding] = nil
+	return f, infos[encoding], encoding
+}
+
+//Open返回的错误转换为状态码，不暴露具体的错误信息
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		return StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return StatusForbidden
+	}
+	return StatusInternalServerError
+}
+
+func serveError(w ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(strconv.Itoa(status) + " " + statusText[status] + "\n"))
+}
+
+//重定向到相对于当前路径的target，保留查询字符串
+func localRedirect(w ResponseWriter, r *Request, target string) {
+	if q := r.Url.RawQuery; q != "" {
+		target += "?" + q
+	}
+	w.Header().Set("Location", target)
+	w.WriteHeader(StatusMovedPermanently)
+}
+
+func dirList(w ResponseWriter, r *Request, f File) {
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		serveError(w, StatusInternalServerError)
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n")
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() {
+			name += "/"
+		}
+		//文件名中可能含有?、#等字符，需要转义后才能作为链接
+		link := url.URL{Path: name}
+		b.WriteString("<a href=\"" + html.EscapeString(link.String()) + "\">" + html.EscapeString(name) + "</a>\n")
+	}
+	b.WriteString("</pre>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+	if r.Method != "HEAD" {
+		w.Write([]byte(b.String()))
+	}
+}
+
+/**
+ServeContent回复content中的内容，支持Range请求：
+	单个区间回复206，Content-Range指明区间的位置
+	多个区间回复206，body为multipart/byteranges
+	区间都超出内容范围时回复416，Content-Range中只给出内容的总长度
+条件请求由CheckPreconditions处理，handler可以在调用之前设置ETag。
+If-Range与当前内容不一致时忽略Range，回复完整内容。name仅用于根据扩展名推断Content-Type，
+无法推断时嗅探内容的前512字节，handler已经设置了Content-Type时不做修改。modtime不为零值时设置Last-Modified
+*/
+func ServeContent(w ResponseWriter, r *Request, name string, modtime time.Time, content io.ReadSeeker) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		serveError(w, StatusInternalServerError)
+		return
+	}
+
+	ctype := w.Header().Get("Content-Type")
+	if ctype == "" {
+		if ctype, err = contentType(name, content); err != nil {
+			serveError(w, StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", ctype)
+	}
+	if !modtime.IsZero() {
+		w.Header().Set("Last-Modified", modtime.UTC().Format(TimeFormat))
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	if CheckPreconditions(w, r, modtime) {
+		return
+	}
+
+	if rh := r.Header.Get("Range"); rh != "" && (r.Method == "GET" || r.Method == "HEAD") && checkIfRange(w, r, modtime) {
+		ranges, err := parseRange(rh, size)
+		switch err {
+		case errUnsatisfiableRange:
+			w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+			serveError(w, StatusRequestedRangeNotSatisfiable)
+			return
+		case nil:
+			//大量重叠的区间会让响应远大于内容本身，这种请求直接回复完整内容
+			var total int64
+			for _, ra := range ranges {
+				total += ra.length
+			}
+			if total <= size {
+				serveRanges(w, r, content, ranges, ctype, size)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(StatusOK)
+	if r.Method != "HEAD" {
+		io.CopyN(w, content, size)
+	}
+}
+
+//根据扩展名推断Content-Type，无法推断时嗅探内容的前512字节，之后将content恢复到开头
+func contentType(name string, content io.ReadSeeker) (string, error) {
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		return ctype, nil
+	}
+	var buf [512]byte
+	n, _ := io.ReadFull(content, buf[:])
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
@@ -0,0 +1,283 @@
+package httpd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+//目录中有a.js和预压缩的a.js.gz
+func precompressedDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.js"), []byte("console.log(1)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.js.gz"), gzipBytes(t, "console.log(1)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFileServerPrecompressedVary(t *testing.T) {
+	files := FileServer(Dir(precompressedDir(t)))
+	//外层已经设置了Vary: Accept-Encoding，不能再添加一次
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Vary", "Accept-Encoding")
+		files.ServeHTTP(w, r)
+	})
+	for _, handler := range []Handler{files, h} {
+		raw := serveRaw(t, handler, "GET /a.js HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n")
+		resp, _ := parseResponse(t, raw, "GET")
+		if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("Content-Encoding = %q, want gzip", got)
+		}
+		if vary := resp.Header.Values("Vary"); len(vary) != 1 || vary[0] != "Accept-Encoding" {
+			t.Errorf("Vary = %q, want a single Accept-Encoding", vary)
+		}
+	}
+}
+
+func TestContainsDotDot(t *testing.T) {
+	tests := map[string]bool{
+		"/":              false,
+		"/a/b.txt":       false,
+		"/a..b/c":        false,
+		"/..a/b..":       false,
+		"/.../x":         false,
+		"/..":            true,
+		"/../etc/passwd": true,
+		"/a/../b":        true,
+		"/a/..":          true,
+		`/a\..\b`:        true,
+		`\..\x`:          true,
+		"..":             true,
+	}
+	for p, want := range tests {
+		if got := containsDotDot(p); got != want {
+			t.Errorf("containsDotDot(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+//Dir中的..最多只能回到根目录本身
+func TestDirOpen(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "public")
+	os.Mkdir(root, 0755)
+	os.WriteFile(filepath.Join(parent, "secret.txt"), []byte("secret"), 0644)
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("A"), 0644)
+
+	d := Dir(root)
+	for _, name := range []string{"/../secret.txt", "../secret.txt", "/a/../../secret.txt", "/../public/../secret.txt"} {
+		if f, err := d.Open(name); err == nil {
+			f.Close()
+			t.Errorf("Open(%q) escaped the root", name)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open(%q) err = %v, want not exist", name, err)
+		}
+	}
+	for _, name := range []string{"/a.txt", "a.txt", "/x/../a.txt", "/../a.txt"} {
+		f, err := d.Open(name)
+		if err != nil {
+			t.Errorf("Open(%q): %v", name, err)
+			continue
+		}
+		data, _ := ioutil.ReadAll(f)
+		f.Close()
+		if string(data) != "A" {
+			t.Errorf("Open(%q) read %q", name, data)
+		}
+	}
+}
+
+func serveFileServer(h Handler, method, target string) *recorder {
+	u, err := url.Parse(target)
+	if err != nil {
+		panic(err)
+	}
+	w := newRecorder()
+	r := newTestRequest(method)
+	r.Url, r.RequestURI = u, target
+	h.ServeHTTP(w, r)
+	return w
+}
+
+//根目录有index.html和a.txt，docs目录没有index.html，其中的文件名含有HTML特殊字符
+func fileServerDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":          "<h1>home</h1>",
+		"a.txt":               "A",
+		"docs/x&y.txt":        "xy",
+		"docs/<b>.txt":        "b",
+		"docs/sub/index.html": "sub index",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestFileServer(t *testing.T) {
+	h := FileServer(Dir(fileServerDir(t)))
+	tests := []struct {
+		method   string
+		target   string
+		status   int
+		body     string
+		location string
+	}{
+		{"GET", "/a.txt", StatusOK, "A", ""},
+		{"HEAD", "/a.txt", StatusOK, "", ""},
+		{"GET", "/", StatusOK, "<h1>home</h1>", ""},
+		{"GET", "/docs/sub/", StatusOK, "sub index", ""},
+		//目录不以/结尾时重定向，保留查询字符串
+		{"GET", "/docs", StatusMovedPermanently, "", "docs/"},
+		{"GET", "/docs/sub?x=1", StatusMovedPermanently, "", "sub/?x=1"},
+		//文件以/结尾时重定向到去掉/的路径
+		{"GET", "/a.txt/", StatusMovedPermanently, "", "../a.txt"},
+		{"GET", "/docs/", StatusForbidden, "", ""},
+		{"GET", "/missing.txt", StatusNotFound, "", ""},
+		{"GET", "/docs/missing/", StatusNotFound, "", ""},
+		{"GET", "/../a.txt", StatusBadRequest, "", ""},
+		{"GET", "/docs/../a.txt", StatusBadRequest, "", ""},
+		{"GET", `/docs\..\a.txt`, StatusBadRequest, "", ""},
+		{"POST", "/a.txt", StatusMethodNotAllowed, "", ""},
+	}
+	for _, tt := range tests {
+		w := serveFileServer(h, tt.method, tt.target)
+		if w.code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.target, w.code, tt.status)
+			continue
+		}
+		if tt.status == StatusOK && w.body.String() != tt.body {
+			t.Errorf("%s %s: body %q, want %q", tt.method, tt.target, w.body.String(), tt.body)
+		}
+		if got := w.Header().Get("Location"); got != tt.location {
+			t.Errorf("%s %s: Location %q, want %q", tt.method, tt.target, got, tt.location)
+		}
+		if tt.status == StatusMethodNotAllowed && w.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s %s: Allow %q", tt.method, tt.target, w.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestFileServerDirectoryListing(t *testing.T) {
+	h := FileServer(Dir(fileServerDir(t)), DirectoryListing())
+	w := serveFileServer(h, "GET", "/docs/")
+	if w.code != StatusOK || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("status %d, Content-Type %q", w.code, w.Header().Get("Content-Type"))
+	}
+	body := w.body.String()
+	for _, want := range []string{
+		`<a href="%3Cb%3E.txt">&lt;b&gt;.txt</a>`,
+		`<a href="sub/">sub/</a>`,
+		`<a href="x&amp;y.txt">x&amp;y.txt</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("listing does not contain %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "<b>") {
+		t.Errorf("file name not escaped:\n%s", body)
+	}
+	//按名称排序
+	if i, j := strings.Index(body, "%3Cb%3E"), strings.Index(body, "x&amp;y"); i > j {
+		t.Errorf("listing not sorted:\n%s", body)
+	}
+	//有index.html的目录仍然回复index.html
+	if w = serveFileServer(h, "GET", "/"); w.body.String() != "<h1>home</h1>" {
+		t.Errorf("/ = %q", w.body.String())
+	}
+}
+
+func TestFileServerFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":    {Data: []byte("embedded home")},
+		"css/app.css":   {Data: []byte("body{}")},
+		"img/a?b#c.png": {Data: []byte("png")},
+	}
+	h := FileServer(FS(fsys), DirectoryListing())
+	tests := []struct {
+		target string
+		status int
+		body   string
+		ctype  string
+	}{
+		{"/", StatusOK, "embedded home", "text/html; charset=utf-8"},
+		{"/css/app.css", StatusOK, "body{}", "text/css; charset=utf-8"},
+		{"/missing", StatusNotFound, "", ""},
+		{"/css", StatusMovedPermanently, "", ""},
+		{"/img/", StatusOK, `<a href="a%3Fb%23c.png">a?b#c.png</a>`, "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		w := serveFileServer(h, "GET", tt.target)
+		if w.code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.target, w.code, tt.status)
+			continue
+		}
+		if tt.status == StatusOK {
+			if !strings.Contains(w.body.String(), tt.body) {
+				t.Errorf("%s: body %q, want %q", tt.target, w.body.String(), tt.body)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.ctype {
+				t.Errorf("%s: Content-Type %q, want %q", tt.target, got, tt.ctype)
+			}
+		}
+	}
+
+	//fs.FS的路径中不能出现..，Open需要先清理成fs.ValidPath
+	for _, name := range []string{"/../index.html", "../index.html", "/css/../index.html"} {
+		f, err := FS(fsys).Open(name)
+		if err != nil {
+			t.Errorf("Open(%q): %v", name, err)
+			continue
+		}
+		f.Close()
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fs.ErrNotExist, StatusNotFound},
+		{&fs.PathError{Op: "open", Path: "x", Err: fs.ErrNotExist}, StatusNotFound},
+		{fs.ErrInvalid, StatusNotFound},
+		{fs.ErrPermission, StatusForbidden},
+		{fmt.Errorf("wrapped: %w", fs.ErrPermission), StatusForbidden},
+		{errors.New("disk on fire"), StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := errorStatus(tt.err); got != tt.want {
+			t.Errorf("errorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
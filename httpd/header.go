@@ -1,17 +1,25 @@
 package httpd
 
+import (
+	"net/textproto"
+	"strings"
+)
+
+//首部字段名大小写不敏感，统一转换为规范形式(如content-length => Content-Length)存储和查找，
+//否则客户端发送小写的content-length时，Get("Content-Length")将取不到值，给请求走私留下可乘之机
 type Header map[string][]string
 
 func (h Header) Add(key string,value string){
+	key = textproto.CanonicalMIMEHeaderKey(key)
 	h[key] = append(h[key],value)
 }
 
 func (h Header) Set(key string,value string){
-	h[key] = []string{value}
+	h[textproto.CanonicalMIMEHeaderKey(key)] = []string{value}
 }
 
 func (h Header) Get(key string) string{
-	if value,ok  := h[key];ok && len(value) > 0{
+	if value,ok  := h[textproto.CanonicalMIMEHeaderKey(key)];ok && len(value) > 0{
 		return value[0]
 	}else{
 		return ""
@@ -19,5 +27,18 @@ func (h Header) Get(key string) string{
 }
 
 func (h Header) Del(key string){
-	delete(h,key)
+	delete(h,textproto.CanonicalMIMEHeaderKey(key))
+}
+
+//向Vary这类以逗号分隔的列表首部中添加token，已经存在(大小写不敏感)或值为*时不重复添加。
+//多个中间件和handler可能各自添加同一个token，直接Add会出现Vary: Accept-Encoding, Accept-Encoding
+func addHeaderToken(h Header,key string,token string){
+	for _,v := range h[textproto.CanonicalMIMEHeaderKey(key)] {
+		for _,t := range strings.Split(v,","){
+			if t = strings.TrimSpace(t);t == "*" || strings.EqualFold(t,token) {
+				return
+			}
+		}
+	}
+	h.Add(key,token)
 }
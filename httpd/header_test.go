@@ -0,0 +1,29 @@
+package httpd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddHeaderToken(t *testing.T) {
+	tests := []struct {
+		have []string
+		want []string
+	}{
+		{nil, []string{"Accept-Encoding"}},
+		{[]string{"Accept-Encoding"}, []string{"Accept-Encoding"}},
+		{[]string{"Origin, accept-encoding"}, []string{"Origin, accept-encoding"}},
+		{[]string{"*"}, []string{"*"}},
+		{[]string{"Origin"}, []string{"Origin", "Accept-Encoding"}},
+	}
+	for _, tt := range tests {
+		h := make(Header)
+		if tt.have != nil {
+			h["Vary"] = append([]string(nil), tt.have...)
+		}
+		addHeaderToken(h, "vary", "Accept-Encoding")
+		if !reflect.DeepEqual(h["Vary"], tt.want) {
+			t.Errorf("Vary %q: got %q, want %q", tt.have, h["Vary"], tt.want)
+		}
+	}
+}
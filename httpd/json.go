@@ -0,0 +1,191 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+//WriteJSON将v编码为JSON并以status回复，Content-Type为application/json; charset=utf-8。
+//编码失败时不会写入任何数据，由调用方决定如何处理
+func WriteJSON(w ResponseWriter, status int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+//DecodeJSON失败的原因以及应当回复的状态码
+type DecodeError struct {
+	Status int //400、413或415
+	Msg    string
+	Err    error //encoding/json或读取body时返回的原始错误，可能为nil
+}
+
+func (e *DecodeError) Error() string {
+	return "httpd: " + e.Msg
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+type jsonOptions struct {
+	maxBytes              int64
+	disallowUnknownFields bool
+}
+
+//DecodeJSON的可选配置
+type JSONOption func(o *jsonOptions)
+
+//body的最大字节数，默认1MB，n小于等于0表示不限制
+func MaxJSONBytes(n int64) JSONOption {
+	return func(o *jsonOptions) {
+		o.maxBytes = n
+	}
+}
+
+//JSON中出现v没有的字段时返回错误
+func DisallowUnknownFields() JSONOption {
+	return func(o *jsonOptions) {
+		o.disallowUnknownFields = true
+	}
+}
+
+//DecodeJSON将请求body解码到v中。Content-Type必须是application/json或以+json结尾，否则返回415；
+//body超过大小限制返回413(同时连接会在响应后关闭)，格式错误、类型不匹配或包含多个JSON值时返回400。
+//错误类型均为*DecodeError，可以直接交给WriteError回复
+func DecodeJSON(r *Request, v interface{}, opts ...JSONOption) error {
+	o := jsonOptions{maxBytes: 1 << 20}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !isJSONMediaType(r.MediaType()) {
+		return &DecodeError{Status: StatusUnsupportedMediaType, Msg: "Content-Type must be application/json"}
+	}
+
+	body := r.Body
+	if o.maxBytes > 0 {
+		body = newMaxBytesReader(r.resp, r.Body, o.maxBytes)
+	}
+	dec := json.NewDecoder(body)
+	if o.disallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return jsonDecodeError(err)
+	}
+	//body中只能有一个JSON值
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return &DecodeError{Status: StatusBadRequest, Msg: "body must contain a single JSON value", Err: err}
+	}
+	return nil
+}
+
+func jsonDecodeError(err error) *DecodeError {
+	var mbe *MaxBytesError
+	var se *json.SyntaxError
+	var ute *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &mbe):
+		return &DecodeError{Status: StatusRequestEntityTooLarge, Msg: "request body too large", Err: err}
+	case err == io.EOF:
+		return &DecodeError{Status: StatusBadRequest, Msg: "request body is empty", Err: err}
+	case err == io.ErrUnexpectedEOF:
+		return &DecodeError{Status: StatusBadRequest, Msg: "request body contains truncated JSON", Err: err}
+	case errors.As(err, &se):
+		return &DecodeError{Status: StatusBadRequest, Msg: "request body contains malformed JSON", Err: err}
+	case errors.As(err, &ute):
+		msg := "invalid value (expected " + ute.Type.String() + ")"
+		if ute.Field != "" {
+			msg = "invalid value for field " + ute.Field + " (expected " + ute.Type.String() + ")"
+		}
+		return &DecodeError{Status: StatusBadRequest, Msg: msg, Err: err}
+	//encoding/json没有为未知字段定义错误类型，只能匹配错误信息
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return &DecodeError{Status: StatusBadRequest, Msg: "request body contains unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field "), Err: err}
+	}
+	return &DecodeError{Status: StatusBadRequest, Msg: err.Error(), Err: err}
+}
+
+//RFC 9457中的problem details，以application/problem+json回复。
+//Extensions中的键值会与标准字段一起输出在同一个JSON对象中
+type Problem struct {
+	Type       string                 `json:"type,omitempty"` //为空时即about:blank
+	Title      string                 `json:"title,omitempty"`
+	Status     int                    `json:"status,omitempty"`
+	Detail     string                 `json:"detail,omitempty"`
+	Instance   string                 `json:"instance,omitempty"`
+	Extensions map[string]interface{} `json:"-"`
+}
+
+//Title默认为状态码的描述
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Title: statusText[status], Status: status, Detail: detail}
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	type problem Problem
+	data, err := json.Marshal((*problem)(p))
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+func WriteProblem(w ResponseWriter, p *Problem) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	status := p.Status
+	if status == 0 {
+		status = StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+//WriteError将httpd返回的错误转换为problem details回复：
+//DecodeError按其状态码，MaxBytesError为413，ValidationErrors为422(字段错误列在errors中)，BindErrors和CharsetError为400，
+//其它错误一律为500且不输出错误信息，避免泄露内部细节
+func WriteError(w ResponseWriter, err error) error {
+	var (
+		de  *DecodeError
+		mbe *MaxBytesError
+		ves ValidationErrors
+		bes BindErrors
+		ce  *CharsetError
+		p   *Problem
+	)
+	switch {
+	case errors.As(err, &de):
+		p = NewProblem(de.Status, de.Msg)
+	case errors.As(err, &mbe):
+		p = NewProblem(StatusRequestEntityTooLarge, mbe.Error())
+	case errors.As(err, &ves):
+		p = NewProblem(StatusUnprocessableEntity, "request validation failed")
+		p.Extensions = map[string]interface{}{"errors": ves}
+	case errors.As(err, &bes):
+		p = NewProblem(StatusBadRequest, bes.Error())
+	case errors.As(err, &ce):
+		p = NewProblem(StatusBadRequest, ce.Error())
+	default:
+		p = NewProblem(StatusInternalServerError, "")
+	}
+	return WriteProblem(w, p)
+}
@@ -0,0 +1,232 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func jsonRequest(contentType, body string) *Request {
+	r := newTestRequest("POST", "Content-Type", contentType)
+	r.Body = strings.NewReader(body)
+	r.parseContentType()
+	return r
+}
+
+type jsonUser struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		ctype  string
+		body   string
+		opts   []JSONOption
+		status int //0表示成功
+	}{
+		{"ok", "application/json", `{"name":"bob","age":30}`, nil, 0},
+		{"charset", "application/json; charset=utf-8", `{"name":"bob"}`, nil, 0},
+		{"+json suffix", "application/problem+json", `{"name":"bob"}`, nil, 0},
+		{"trailing whitespace", "application/json", "{\"name\":\"bob\"}\n\t ", nil, 0},
+		{"unknown field allowed", "application/json", `{"name":"bob","admin":true}`, nil, 0},
+
+		{"text/plain", "text/plain", `{"name":"bob"}`, nil, StatusUnsupportedMediaType},
+		{"form", "application/x-www-form-urlencoded", `name=bob`, nil, StatusUnsupportedMediaType},
+		{"no content type", "", `{"name":"bob"}`, nil, StatusUnsupportedMediaType},
+		{"json-ish", "application/jsonp", `{"name":"bob"}`, nil, StatusUnsupportedMediaType},
+
+		{"empty", "application/json", "", nil, StatusBadRequest},
+		{"truncated", "application/json", `{"name":"bo`, nil, StatusBadRequest},
+		{"malformed", "application/json", `{"name":bob}`, nil, StatusBadRequest},
+		{"wrong type", "application/json", `{"age":"thirty"}`, nil, StatusBadRequest},
+		{"trailing value", "application/json", `{"name":"bob"}{"name":"eve"}`, nil, StatusBadRequest},
+		{"trailing garbage", "application/json", `{"name":"bob"} x`, nil, StatusBadRequest},
+		{"unknown field", "application/json", `{"name":"bob","admin":true}`, []JSONOption{DisallowUnknownFields()}, StatusBadRequest},
+
+		{"within limit", "application/json", `{"name":"bob"}`, []JSONOption{MaxJSONBytes(14)}, 0},
+		{"over limit", "application/json", `{"name":"bob"}`, []JSONOption{MaxJSONBytes(13)}, StatusRequestEntityTooLarge},
+		{"over default limit", "application/json", `{"name":"` + strings.Repeat("a", 1<<20) + `"}`, nil, StatusRequestEntityTooLarge},
+		{"limit disabled", "application/json", `{"name":"` + strings.Repeat("a", 1<<20) + `"}`, []JSONOption{MaxJSONBytes(0)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var u jsonUser
+			err := DecodeJSON(jsonRequest(tt.ctype, tt.body), &u, tt.opts...)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("err = %v", err)
+				}
+				if !strings.HasPrefix(u.Name, "bob") && !strings.HasPrefix(u.Name, "aaa") {
+					t.Errorf("decoded %+v", u)
+				}
+				return
+			}
+			var de *DecodeError
+			if !errors.As(err, &de) || de.Status != tt.status {
+				t.Fatalf("err = %#v, want *DecodeError with status %d", err, tt.status)
+			}
+			if tt.status == StatusRequestEntityTooLarge {
+				var mbe *MaxBytesError
+				if !errors.As(err, &mbe) {
+					t.Errorf("413 error does not wrap *MaxBytesError: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeJSONMessages(t *testing.T) {
+	var u jsonUser
+	err := DecodeJSON(jsonRequest("application/json", `{"age":"x"}`), &u)
+	if err == nil || !strings.Contains(err.Error(), "field age") {
+		t.Errorf("type error: %v", err)
+	}
+	err = DecodeJSON(jsonRequest("application/json", `{"role":"admin"}`), &u, DisallowUnknownFields())
+	if err == nil || !strings.Contains(err.Error(), `unknown field "role"`) {
+		t.Errorf("unknown field error: %v", err)
+	}
+}
+
+//经过连接时body超限回复413并关闭连接
+func TestDecodeJSONTooLargeWire(t *testing.T) {
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		var u jsonUser
+		if err := DecodeJSON(r, &u, MaxJSONBytes(8)); err != nil {
+			WriteError(w, err)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	body := `{"name":"bob"}`
+	raw := serveRaw(t, h, "POST / HTTP/1.1\r\nHost: a\r\nContent-Type: application/json\r\nContent-Length: 14\r\n\r\n"+body)
+	resp, _ := parseResponse(t, raw, "POST")
+	if resp.StatusCode != StatusRequestEntityTooLarge || !resp.Close {
+		t.Errorf("status %d, close %v", resp.StatusCode, resp.Close)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	w := newRecorder()
+	if err := WriteJSON(w, StatusCreated, jsonUser{"bob", 30}); err != nil {
+		t.Fatal(err)
+	}
+	if w.code != StatusCreated || w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("status %d, Content-Type %q", w.code, w.Header().Get("Content-Type"))
+	}
+	if got := w.body.String(); got != "{\"name\":\"bob\",\"age\":30}\n" {
+		t.Errorf("body %q", got)
+	}
+
+	//编码失败时不写入任何数据
+	w = newRecorder()
+	if err := WriteJSON(w, StatusOK, map[string]interface{}{"c": make(chan int)}); err == nil {
+		t.Error("unsupported value encoded")
+	}
+	if w.code != 0 || w.body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+		t.Errorf("partial response: status %d, body %q", w.code, w.body.String())
+	}
+}
+
+func TestProblemMarshal(t *testing.T) {
+	p := NewProblem(StatusForbidden, "no access")
+	p.Type = "https://example.com/probs/out-of-credit"
+	p.Instance = "/account/12345"
+	p.Extensions = map[string]interface{}{
+		"balance":  30,
+		"accounts": []string{"/account/12345"},
+		//扩展成员不能覆盖标准字段
+		"status": 999,
+	}
+	w := newRecorder()
+	if err := WriteProblem(w, p); err != nil {
+		t.Fatal(err)
+	}
+	if w.code != StatusForbidden || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("status %d, Content-Type %q", w.code, w.Header().Get("Content-Type"))
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(w.body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"type":     "https://example.com/probs/out-of-credit",
+		"title":    "Forbidden",
+		"status":   float64(403),
+		"detail":   "no access",
+		"instance": "/account/12345",
+		"balance":  float64(30),
+		"accounts": []interface{}{"/account/12345"},
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if gv, ok := got[k]; !ok || !jsonEqual(gv, v) {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+
+	//Status为0时回复500，空字段不输出
+	w = newRecorder()
+	WriteProblem(w, &Problem{Title: "oops"})
+	if w.code != StatusInternalServerError || strings.TrimSpace(w.body.String()) != `{"title":"oops"}` {
+		t.Errorf("status %d, body %q", w.code, w.body.String())
+	}
+}
+
+func jsonEqual(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		detail string
+	}{
+		{"decode", &DecodeError{Status: StatusUnsupportedMediaType, Msg: "Content-Type must be application/json"},
+			StatusUnsupportedMediaType, "Content-Type must be application/json"},
+		{"max bytes", &MaxBytesError{Limit: 10}, StatusRequestEntityTooLarge, (&MaxBytesError{Limit: 10}).Error()},
+		{"wrapped max bytes", &ContentDecodingError{Encoding: "gzip", Err: &MaxBytesError{Limit: 10}},
+			StatusRequestEntityTooLarge, (&MaxBytesError{Limit: 10}).Error()},
+		{"validation", ValidationErrors{{Field: "email", Rule: "required", Message: "is required"}},
+			StatusUnprocessableEntity, "request validation failed"},
+		{"bind", BindErrors{{Field: "age", Source: "query", Err: errors.New("bad")}}, StatusBadRequest, ""},
+		{"charset", &CharsetError{Charset: "x-unknown"}, StatusBadRequest, ""},
+		//其它错误不暴露内部细节
+		{"internal", errors.New("database password is hunter2"), StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		w := newRecorder()
+		if err := WriteError(w, tt.err); err != nil {
+			t.Fatal(err)
+		}
+		if w.code != tt.status || w.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s: status %d, Content-Type %q", tt.name, w.code, w.Header().Get("Content-Type"))
+		}
+		var p map[string]interface{}
+		if err := json.Unmarshal(w.body.Bytes(), &p); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if p["status"] != float64(tt.status) || p["title"] != statusText[tt.status] {
+			t.Errorf("%s: problem %v", tt.name, p)
+		}
+		if tt.detail != "" && p["detail"] != tt.detail {
+			t.Errorf("%s: detail %v, want %q", tt.name, p["detail"], tt.detail)
+		}
+		if tt.status == StatusInternalServerError && p["detail"] != nil {
+			t.Errorf("%s: internal error leaked: %v", tt.name, p["detail"])
+		}
+		if tt.status == StatusUnprocessableEntity {
+			if errs, _ := p["errors"].([]interface{}); len(errs) != 1 {
+				t.Errorf("%s: errors %v", tt.name, p["errors"])
+			}
+		}
+	}
+}
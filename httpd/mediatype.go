@@ -0,0 +1,234 @@
+package httpd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var errInvalidParams = errors.New("invalid header parameters")
+
+//parseHeaderParams解析Content-Type、Content-Disposition这类带参数的首部值，
+//如`form-data; name="a"; filename*=UTF-8''%E4%B8%AD.txt`，返回小写的主值以及参数。
+//参数名统一转换为小写；参数值可以是token或quoted-string，并按RFC 2231合并续行参数(name*0、name*1)、解码带字符集的参数(name*)
+func parseHeaderParams(v string) (value string, params map[string]string, err error) {
+	i := strings.IndexByte(v, ';')
+	if i == -1 {
+		i = len(v)
+	}
+	value = strings.ToLower(strings.TrimSpace(v[:i]))
+	v = v[i:]
+
+	raw := make(map[string]string)
+	for {
+		v = strings.TrimLeft(v, " \t")
+		if v == "" {
+			break
+		}
+		if v[0] != ';' {
+			return "", nil, errInvalidParams
+		}
+		v = strings.TrimLeft(v[1:], " \t")
+		//允许末尾多出一个分号
+		if v == "" {
+			break
+		}
+		var key, val string
+		if key, v = consumeToken(v); key == "" {
+			return "", nil, errInvalidParams
+		}
+		v = strings.TrimLeft(v, " \t")
+		if v == "" || v[0] != '=' {
+			return "", nil, errInvalidParams
+		}
+		v = strings.TrimLeft(v[1:], " \t")
+		if val, v, err = consumeValue(v); err != nil {
+			return "", nil, err
+		}
+		key = strings.ToLower(key)
+		if _, ok := raw[key]; ok {
+			return "", nil, errInvalidParams
+		}
+		raw[key] = val
+	}
+	return value, decodeRFC2231(raw), nil
+}
+
+//ParseMediaType解析Content-Type首部的值，如`multipart/form-data; charset=utf-8; boundary="a=b"`，
+//返回小写的媒体类型(multipart/form-data)以及参数。参数名大小写不敏感，统一转换为小写；参数值保持原样
+func ParseMediaType(v string) (mediaType string, params map[string]string, err error) {
+	mediaType, params, err = parseHeaderParams(v)
+	if err != nil {
+		return "", nil, err
+	}
+	i := strings.IndexByte(mediaType, '/')
+	if i == -1 {
+		return "", nil, errors.New("mediatype: missing subtype")
+	}
+	if t, rest := consumeToken(mediaType[:i]); t == "" || rest != "" {
+		return "", nil, errors.New("mediatype: invalid type")
+	}
+	if t, rest := consumeToken(mediaType[i+1:]); t == "" || rest != "" {
+		return "", nil, errors.New("mediatype: invalid subtype")
+	}
+	return mediaType, params, nil
+}
+
+//RFC 2045中的tspecials，不能出现在token里
+func isTSpecial(c byte) bool {
+	return strings.IndexByte(`()<>@,;:\"/[]?=`, c) != -1
+}
+
+func isTokenChar(c byte) bool {
+	return c > 0x20 && c < 0x7f && !isTSpecial(c)
+}
+
+func consumeToken(v string) (token, rest string) {
+	i := 0
+	for i < len(v) && isTokenChar(v[i]) {
+		i++
+	}
+	return v[:i], v[i:]
+}
+
+func consumeValue(v string) (value, rest string, err error) {
+	if v == "" {
+		return "", "", errInvalidParams
+	}
+	if v[0] != '"' {
+		if value, rest = consumeToken(v); value == "" {
+			return "", "", errInvalidParams
+		}
+		return
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == '"':
+			return b.String(), v[i+1:], nil
+		//IE上传文件时会发送未转义的完整路径，如"C:\dir\a.txt"，因此只把\"和\\当作转义
+		case c == '\\' && i+1 < len(v) && (v[i+1] == '"' || v[i+1] == '\\'):
+			i++
+			b.WriteByte(v[i])
+		case c == '\r' || c == '\n':
+			return "", "", errInvalidParams
+		default:
+			b.WriteByte(c)
+		}
+	}
+	//缺少结尾的引号
+	return "", "", errInvalidParams
+}
+
+//decodeRFC2231合并续行参数并解码带字符集的参数：
+//	filename*=UTF-8''%E4%B8%AD.txt
+//	filename*0*=UTF-8''%E4%B8%AD; filename*1=".txt"
+//同名的扩展参数优先于普通参数，无法解码的扩展参数将被忽略
+func decodeRFC2231(raw map[string]string) map[string]string {
+	params := make(map[string]string, len(raw))
+	for key, val := range raw {
+		if !strings.Contains(key, "*") {
+			params[key] = val
+		}
+	}
+	for key, val := range raw {
+		if !strings.HasSuffix(key, "*") || strings.Count(key, "*") != 1 {
+			continue
+		}
+		if s, ok := decodeExtValue(val); ok {
+			params[key[:len(key)-1]] = s
+		}
+	}
+
+	//续行参数从name*0开始按编号拼接，只有第一段可以携带字符集
+	for key := range raw {
+		if !strings.HasSuffix(key, "*0") && !strings.HasSuffix(key, "*0*") {
+			continue
+		}
+		name := key[:strings.Index(key, "*")]
+		var b strings.Builder
+		charset := ""
+		ok := true
+		for n := 0; ok; n++ {
+			seg := name + "*" + strconv.Itoa(n)
+			if val, exist := raw[seg]; exist {
+				b.WriteString(val)
+			} else if val, exist = raw[seg+"*"]; exist {
+				if n == 0 {
+					parts := strings.SplitN(val, "'", 3)
+					if len(parts) != 3 {
+						ok = false
+						break
+					}
+					charset, val = parts[0], parts[2]
+				}
+				s, err := percentDecode(val)
+				if err != nil {
+					ok = false
+					break
+				}
+				b.WriteString(s)
+			} else {
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		if s, decoded := decodeCharset(charset, b.String()); decoded {
+			params[name] = s
+		}
+	}
+	return params
+}
+
+//解析charset'language'percent-encoded形式的扩展值
+func decodeExtValue(v string) (string, bool) {
+	parts := strings.SplitN(v, "'", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+	s, err := percentDecode(parts[2])
+	if err != nil {
+		return "", false
+	}
+	return decodeCharset(parts[0], s)
+}
+
+func percentDecode(s string) (string, error) {
+	if strings.IndexByte(s, '%') == -1 {
+		return s, nil
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", errInvalidParams
+		}
+		n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", errInvalidParams
+		}
+		b = append(b, byte(n))
+		i += 2
+	}
+	return string(b), nil
+}
+
+//将字符集为charset的s转换为UTF-8，charset为空表示未经编码(续行参数的第一段不带*时)
+func decodeCharset(charset, s string) (string, bool) {
+	if charset == "" {
+		return s, utf8.ValidString(s)
+	}
+	decoder, ok := lookupCharset(charset)
+	if !ok {
+		return "", false
+	}
+	return decoder([]byte(s))
+}
@@ -0,0 +1,203 @@
+package httpd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaderParams(t *testing.T) {
+	tests := []struct {
+		in     string
+		value  string
+		params map[string]string
+		err    bool
+	}{
+		{`form-data; name="a"; filename="b.txt"`, "form-data", map[string]string{"name": "a", "filename": "b.txt"}, false},
+		{`Form-Data;NAME=a`, "form-data", map[string]string{"name": "a"}, false},
+		{`attachment`, "attachment", map[string]string{}, false},
+		{`attachment;`, "attachment", map[string]string{}, false},
+		{`form-data; name = "a" ; `, "form-data", map[string]string{"name": "a"}, false},
+		//quoted-string中可以出现;和=
+		{`form-data; name="a;b=c"`, "form-data", map[string]string{"name": "a;b=c"}, false},
+		{`form-data; name=""`, "form-data", map[string]string{"name": ""}, false},
+		//只有\"和\\是转义，其它反斜杠原样保留(IE会发送未转义的Windows路径)
+		{`form-data; name="a\"b"`, "form-data", map[string]string{"name": `a"b`}, false},
+		{`form-data; name="a\\b"`, "form-data", map[string]string{"name": `a\b`}, false},
+		{`form-data; filename="C:\dir\a.txt"`, "form-data", map[string]string{"filename": `C:\dir\a.txt`}, false},
+
+		//参数名大小写不敏感，重复的参数有歧义
+		{`form-data; name="a"; name="b"`, "", nil, true},
+		{`form-data; Name="a"; name="b"`, "", nil, true},
+		{`form-data; name="a`, "", nil, true},
+		{`form-data; name=`, "", nil, true},
+		{`form-data; name`, "", nil, true},
+		{`form-data; ="a"`, "", nil, true},
+		{"form-data; name=\"a\r\nb\"", "", nil, true},
+		{`form-data; name=a b`, "", nil, true},
+	}
+	for _, tt := range tests {
+		value, params, err := parseHeaderParams(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parseHeaderParams(%q) err = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if value != tt.value || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("parseHeaderParams(%q) = %q, %q; want %q, %q", tt.in, value, params, tt.value, tt.params)
+		}
+	}
+}
+
+func TestParseHeaderParamsRFC2231(t *testing.T) {
+	tests := []struct {
+		in   string
+		name string
+		want string
+	}{
+		{`attachment; filename*=UTF-8''%E4%B8%AD%E6%96%87.txt`, "filename", "中文.txt"},
+		//字符集大小写不敏感，语言标签被忽略
+		{`attachment; filename*=utf-8'zh-CN'%E4%B8%AD.txt`, "filename", "中.txt"},
+		{`attachment; title*=us-ascii'en-us'This%20is%20%2A%2A%2Afun%2A%2A%2A`, "title", "This is ***fun***"},
+		{`attachment; filename*=ISO-8859-1''%E9t%E9.txt`, "filename", "été.txt"},
+		{`attachment; filename*=GBK''%D6%D0%CE%C4.txt`, "filename", "中文.txt"},
+		//扩展参数优先于普通参数，与出现的顺序无关
+		{`attachment; filename="fallback.txt"; filename*=UTF-8''%E4%B8%AD.txt`, "filename", "中.txt"},
+		{`attachment; filename*=UTF-8''%E4%B8%AD.txt; filename="fallback.txt"`, "filename", "中.txt"},
+		//无法解码的扩展参数被忽略，使用普通参数
+		{`attachment; filename="fallback.txt"; filename*=x-unknown''abc`, "filename", "fallback.txt"},
+		{`attachment; filename="fallback.txt"; filename*=UTF-8''%ZZ`, "filename", "fallback.txt"},
+		{`attachment; filename="fallback.txt"; filename*=UTF-8''%E4%B8`, "filename", "fallback.txt"},
+		{`attachment; filename="fallback.txt"; filename*=%E4%B8%AD`, "filename", "fallback.txt"},
+		{`attachment; filename="fallback.txt"; filename*=us-ascii''%E4`, "filename", "fallback.txt"},
+
+		//续行参数：只有第一段携带字符集，带*的段需要百分号解码，不带*的段原样拼接
+		{`attachment; filename*0*=UTF-8''%E4%B8%AD; filename*1=".txt"`, "filename", "中.txt"},
+		{`message/external-body; access-type=URL; URL*0="ftp://"; URL*1="cs.utk.edu/pub/moore/bulk-mailer/bulk-mailer.tar"`,
+			"url", "ftp://cs.utk.edu/pub/moore/bulk-mailer/bulk-mailer.tar"},
+		{`attachment; title*0*=us-ascii'en'This%20is%20even%20more%20; title*1*=%2A%2A%2Afun%2A%2A%2A%20; title*2="isn't it!"`,
+			"title", "This is even more ***fun*** isn't it!"},
+		//多字节字符被拆到两段中
+		{`attachment; filename*0*=UTF-8''%E4%B8; filename*1*=%AD.txt`, "filename", "中.txt"},
+		//段的顺序无关，编号不连续时在缺口处截止
+		{`attachment; filename*1=b; filename*0=a; filename*3=d`, "filename", "ab"},
+		{`attachment; filename*0=a; filename*1=b; filename="plain"`, "filename", "ab"},
+		//没有*0的续行参数无效
+		{`attachment; filename*1=b; filename="plain"`, "filename", "plain"},
+		{`attachment; filename*0*=x-unknown''a; filename*1=b; filename="plain"`, "filename", "plain"},
+	}
+	for _, tt := range tests {
+		_, params, err := parseHeaderParams(tt.in)
+		if err != nil {
+			t.Errorf("parseHeaderParams(%q): %v", tt.in, err)
+			continue
+		}
+		if got := params[tt.name]; got != tt.want {
+			t.Errorf("parseHeaderParams(%q)[%s] = %q, want %q", tt.in, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := map[string]string{
+		"a.txt":                  "a.txt",
+		"中文.txt":                 "中文.txt",
+		"dir/a.txt":              "a.txt",
+		"../../etc/passwd":       "passwd",
+		`C:\Users\bob\a.txt`:     "a.txt",
+		`..\..\windows\win.ini`:  "win.ini",
+		"dir/":                   "",
+		".":                      "",
+		"..":                     "",
+		"../..":                  "",
+		"..\x00":                 "",
+		"evil.php\x00.jpg":       "evil.php.jpg",
+		"a\r\nb.txt":             "ab.txt",
+		"a\x7f.txt":              "a.txt",
+		"dir\x00/a.txt":          "a.txt",
+		"\xd6\xd0\xce\xc4.txt":   "\xd6\xd0\xce\xc4.txt", //GBK编码，保持原样
+		"name with spaces .txt":  "name with spaces .txt",
+		"a/b\\c/d\\..\\e.tar.gz": "e.tar.gz",
+	}
+	for in, want := range tests {
+		if got := sanitizeFilename(in); got != want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+//上传的文件名经过Content-Disposition解析和清理
+func TestPartFileName(t *testing.T) {
+	tests := map[string]string{
+		`form-data; name="f"; filename="../../a.txt"`:                        "a.txt",
+		`form-data; name="f"; filename*=UTF-8''..%2F..%2F%E4%B8%AD.txt`:      "中.txt",
+		`form-data; name="f"; filename*=UTF-8''evil.php%00.jpg`:              "evil.php.jpg",
+		`form-data; name="f"; filename*0*=UTF-8''dir%5C; filename*1="b.txt"`: "b.txt",
+		`form-data; name="f"; filename=".."`:                                 "",
+	}
+	for cd, want := range tests {
+		p := &Part{Header: Header{"Content-Disposition": {cd}}}
+		if got := p.FileName(); got != want {
+			t.Errorf("%s: FileName() = %q, want %q", cd, got, want)
+		}
+		if p.FormName() != "f" {
+			t.Errorf("%s: FormName() = %q", cd, p.FormName())
+		}
+	}
+}
+
+func TestParseMediaType(t *testing.T) {
+	tests := []struct {
+		in        string
+		mediaType string
+		params    map[string]string
+		err       bool
+	}{
+		{"text/html", "text/html", map[string]string{}, false},
+		{"Text/HTML; Charset=UTF-8", "text/html", map[string]string{"charset": "UTF-8"}, false},
+		{`multipart/form-data; boundary="a=b; c"`, "multipart/form-data", map[string]string{"boundary": "a=b; c"}, false},
+		{`multipart/related; type="application/json"; start="<root>"; boundary=abc`, "multipart/related",
+			map[string]string{"type": "application/json", "start": "<root>", "boundary": "abc"}, false},
+		{"application/vnd.api+json ; charset=utf-8 ;", "application/vnd.api+json", map[string]string{"charset": "utf-8"}, false},
+		{`text/plain; title*=UTF-8''%E4%B8%AD`, "text/plain", map[string]string{"title": "中"}, false},
+
+		{"", "", nil, true},
+		{"text", "", nil, true},
+		{"text/", "", nil, true},
+		{"/html", "", nil, true},
+		{"text/html/x", "", nil, true},
+		{"text /html", "", nil, true},
+		{"text/html x", "", nil, true},
+		{"text/html; charset", "", nil, true},
+		{"text/html; charset=utf-8; charset=latin1", "", nil, true},
+		{`text/html; charset="utf-8`, "", nil, true},
+	}
+	for _, tt := range tests {
+		mt, params, err := ParseMediaType(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseMediaType(%q) err = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if mt != tt.mediaType || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("ParseMediaType(%q) = %q, %q; want %q, %q", tt.in, mt, params, tt.mediaType, tt.params)
+		}
+	}
+}
+
+func TestRequestContentType(t *testing.T) {
+	r := newTestRequest("POST", "Content-Type", `Multipart/Form-Data; Boundary="xyz"; Charset=GBK`)
+	r.parseContentType()
+	if r.MediaType() != "multipart/form-data" || r.Charset() != "gbk" || r.boundary != "xyz" {
+		t.Errorf("MediaType %q, Charset %q, boundary %q", r.MediaType(), r.Charset(), r.boundary)
+	}
+	params := r.ContentTypeParams()
+	params["boundary"] = "changed"
+	if r.ContentTypeParams()["boundary"] != "xyz" {
+		t.Error("ContentTypeParams returned the internal map")
+	}
+
+	//无法解析的Content-Type当作未设置
+	r = newTestRequest("POST", "Content-Type", "text/html; charset")
+	r.parseContentType()
+	if r.MediaType() != "" || r.Charset() != "" || len(r.ContentTypeParams()) != 0 {
+		t.Errorf("invalid Content-Type: MediaType %q, Charset %q", r.MediaType(), r.Charset())
+	}
+}
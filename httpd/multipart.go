@@ -31,6 +31,11 @@ type MultipartReader struct {
 	//因此我们用curPart记录当前是哪个part占有了bufr，方便我们对其管理。
 	curPart	*Part					//当前读取到哪个part
 	crlf 	[2]byte					//用于消费掉\r\n
+	maxHeaderBytes int				//每个part首部的最大字节数，小于等于0表示不限制
+	partCount int					//已经读取的part数量
+	mediaType string				//报文的媒体类型，如multipart/related
+	params map[string]string		//Content-Type中的参数
+	progress func(p *Part, n int64)	//读取进度回调
 }
 
 //传入的r将是Request的Body
@@ -45,6 +50,44 @@ func NewMultipartReader(r io.Reader,boundary string) *MultipartReader{
 	}
 }
 
+//根据Content-Type创建MultipartReader，媒体类型必须是multipart/*且带有boundary参数
+func newMultipartReaderFromType(r io.Reader,mediaType string,params map[string]string) (*MultipartReader,error) {
+	if !strings.HasPrefix(mediaType,"multipart/") {
+		return nil,fmt.Errorf("%s is not a multipart media type",mediaType)
+	}
+	if params["boundary"] == "" {
+		return nil,errors.New("no boundary detected")
+	}
+	mr := NewMultipartReader(r,params["boundary"])
+	mr.mediaType,mr.params = mediaType,params
+	return mr,nil
+}
+
+//SetProgressFunc设置读取进度回调，每次从part中读出数据后都会以该part已读取的总字节数调用fn。
+//fn在读取数据的goroutine中同步执行，不应阻塞太久
+func (mr *MultipartReader) SetProgressFunc(fn func(p *Part, n int64)) {
+	mr.progress = fn
+}
+
+//返回报文的媒体类型，如multipart/mixed，直接通过NewMultipartReader创建时为空
+func (mr *MultipartReader) MediaType() string {
+	return mr.mediaType
+}
+
+//返回Content-Type中的参数，如multipart/related的start、type
+func (mr *MultipartReader) Param(key string) string {
+	return mr.params[strings.ToLower(key)]
+}
+
+//multipart/related报文由一个根part和它引用的其它part组成(如HTML和其中的图片)。
+//start参数给出根part的Content-ID，未指定start时第一个part就是根part
+func (mr *MultipartReader) IsRoot(p *Part) bool {
+	if start := trimContentID(mr.Param("start"));start != "" {
+		return p.ContentID() == start
+	}
+	return p.index == 0
+}
+
 // https://www.gufeijun.com
 func (mr *MultipartReader) NextPart() (p *Part, err error) {
 	if mr.curPart != nil {
@@ -56,19 +99,31 @@ func (mr *MultipartReader) NextPart() (p *Part, err error) {
 			return
 		}
 	}
-	line, err := mr.readLine()
-	if err != nil {
-		return
-	}
-	if bytes.Equal(line, mr.dashBoundaryDash) {
-		return nil, io.EOF
-	}
-	if !bytes.Equal(line, mr.dashBoundary) {
+	for {
+		var line []byte
+		line, err = mr.readLine()
+		if err != nil {
+			return
+		}
+		//分隔符所在行的末尾允许有空白(transport-padding)
+		line = bytes.TrimRight(line, " \t")
+		if bytes.Equal(line, mr.dashBoundaryDash) {
+			return nil, io.EOF
+		}
+		if bytes.Equal(line, mr.dashBoundary) {
+			break
+		}
+		//第一个分隔符之前可能有一段前言(preamble)，如邮件中的"This is a multi-part message in MIME format."，直接跳过
+		if mr.curPart == nil {
+			continue
+		}
 		err = fmt.Errorf("want delimiter %s, but got %s", mr.dashBoundary, line)
 		return
 	}
 	p = new(Part)
 	p.mr = mr
+	p.index = mr.partCount
+	mr.partCount++
 	if err = p.readHeader(); err != nil {
 		return
 	}
@@ -78,27 +133,214 @@ func (mr *MultipartReader) NextPart() (p *Part, err error) {
 
 func (mr *MultipartReader) discardCRLF() (err error) {
 	if _, err = io.ReadFull(mr.bufr, mr.crlf[:]); err == nil {
-		if mr.crlf[0] != '\r' && mr.crlf[1] != '\n' {
+		if mr.crlf[0] != '\r' || mr.crlf[1] != '\n' {
 			err = fmt.Errorf("expect crlf, but got %s", mr.crlf)
 		}
 	}
 	return
 }
 
+//preamble和分隔符所在行不是HTTP首部，沿用宽松的读法，允许单独的\n作为行尾
 func (mr *MultipartReader) readLine() ([]byte, error) {
-	return readLine(mr.bufr)
+	p, isPrefix, err := mr.bufr.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	line := append([]byte(nil), p...)
+	for isPrefix {
+		if p, isPrefix, err = mr.bufr.ReadLine(); err != nil {
+			return nil, err
+		}
+		line = append(line, p...)
+	}
+	return line, nil
+}
+
+var ErrMessageTooLarge = errors.New("multipart: message too large")
+
+//ReadForm的可选配置，字段为零值时使用默认值
+type MultipartOptions struct {
+	MaxValueMemory     int64  //非文件部分在内存中存储的最大总量，超出返回ErrMessageTooLarge，默认10MB
+	MaxFileMemory      int64  //文件在内存中存储的最大总量，超出的文件存储到硬盘，默认30MB，小于0表示文件全部存储到硬盘
+	MaxParts           int    //part的最大数量，超出返回ErrMessageTooLarge，默认1000
+	MaxPartHeaderBytes int    //每个part首部的最大字节数，默认10KB
+	MaxTotalSize       int64  //所有part内容的最大总量，超出返回ErrMessageTooLarge，默认不限制
+	TempDir            string //暂存文件的目录，默认为os.TempDir()
+	FileStore          FileStore //文件的存储后端，设置后文件part直接流式写入其中，MaxFileMemory和TempDir不再生效
+	OnProgress         func(p *Part, n int64) //读取进度回调，见MultipartReader.SetProgressFunc
+	Digests            []DigestAlgorithm //读取文件时计算的摘要，结果保存在FileHeader.Digests中
+	//校验文件part的Content-MD5、Digest(RFC 3230)或Content-Digest(RFC 9530)首部，不一致时返回ErrDigestMismatch。
+	//首部中出现的算法即使不在Digests中也会计算
+	VerifyDigests      bool
 }
 
-func (mr *MultipartReader) ReadForm() (mf *MultipartForm,err error) {
-	mf = &MultipartForm{
-		Value: make(map[string]string),
-		File:  make(map[string]*FileHeader),
+func (o *MultipartOptions) withDefaults() MultipartOptions {
+	var opts MultipartOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.MaxValueMemory == 0 {
+		opts.MaxValueMemory = 10 << 20
+	}
+	if opts.MaxFileMemory == 0 {
+		opts.MaxFileMemory = 30 << 20
+	} else if opts.MaxFileMemory < 0 {
+		opts.MaxFileMemory = 0
+	}
+	if opts.MaxParts == 0 {
+		opts.MaxParts = 1000
 	}
+	if opts.MaxPartHeaderBytes == 0 {
+		opts.MaxPartHeaderBytes = 10 << 10
+	}
+	return opts
+}
 
-	var part *Part
-	var nonFileMaxMemory int64 = 10 << 20	//非文件部分在内存中存取的最大量10MB,超出返回错误
-	var fileMaxMemory int64 = 30 << 20		//文件在内存中存取的最大量30MB,超出部分存储到硬盘
+//opts为nil时使用默认配置
+func (mr *MultipartReader) ReadForm(opts *MultipartOptions) (mf *MultipartForm,err error) {
+	form := &MultipartForm{
+		Value: make(map[string][]string),
+		File:  make(map[string][]*FileHeader),
+	}
+	//出错时将已经暂存到硬盘或写入存储后端的文件删除
+	defer func() {
+		if err != nil {
+			form.discard()
+		}
+	}()
 
+	o := opts.withDefaults()
+	mr.maxHeaderBytes = o.MaxPartHeaderBytes
+	if o.OnProgress != nil {
+		mr.progress = o.OnProgress
+	}
+	nonFileMaxMemory := o.MaxValueMemory	//非文件部分在内存中存取的剩余量,超出返回错误
+	fileMaxMemory := o.MaxFileMemory		//文件在内存中存取的剩余量,超出部分存储到硬盘
+	totalSize := o.MaxTotalSize				//所有part内容的剩余量，小于等于0表示不限制
+	//每个part最多拷贝的字节数，同样多拷贝1个字节，好判断是否超过了总量限制
+	limitN := func(limit int64) int64 {
+		if o.MaxTotalSize > 0 && totalSize+1 < limit {
+			return totalSize+1
+		}
+		return limit
+	}
+	consume := func(n int64) error {
+		if o.MaxTotalSize <= 0 {
+			return nil
+		}
+		if totalSize -= n;totalSize < 0 {
+			return ErrMessageTooLarge
+		}
+		return nil
+	}
+
+	//读取文件part，根据配置存入FileStore、内存或暂存文件
+	readFile := func(p *Part) (fh *FileHeader, err error) {
+		fh = &FileHeader{
+			Filename: p.FileName(),
+			Header:   p.Header,
+		}
+		//边读取边计算摘要，文件内容只需要经过一次。
+		//摘要在读到EOF时校验，不一致时返回ErrDigestMismatch代替io.EOF，FileStore.Put在提交文件之前就会失败
+		var part io.Reader = p
+		if d := newDigester(o.Digests, p.Header, o.VerifyDigests); d != nil {
+			dr := &digestReader{r: p, d: d}
+			part = dr
+			defer func() {
+				if err != nil {
+					return
+				}
+				//存储后端没有读到EOF时摘要还没有校验
+				if !dr.done {
+					if _, err = io.Copy(ioutil.Discard, dr); err != nil {
+						fh.discard()
+						fh = nil
+						return
+					}
+				}
+				fh.Digests = dr.sums
+			}()
+		}
+
+		//配置了FileStore时，文件内容直接写入存储后端，不再经过内存或暂存文件
+		if o.FileStore != nil {
+			cr := &countReader{r: part}
+			var src io.Reader = cr
+			if o.MaxTotalSize > 0 {
+				if totalSize <= 0 {
+					//额度已经用完。maxBytesReader把小于等于0的上限当作不限制，这里直接判断part是否还有内容
+					var b [1]byte
+					if n, _ := io.ReadFull(cr, b[:]); n > 0 {
+						return nil, ErrMessageTooLarge
+					}
+				} else {
+					src = newMaxBytesReader(nil, cr, totalSize)
+				}
+			}
+			fh.key, err = o.FileStore.Put(fh, src)
+			//存储后端可能包装读取时的错误，如fmt.Errorf("upload: %w", err)
+			var mbe *MaxBytesError
+			if errors.As(err, &mbe) {
+				err = ErrMessageTooLarge
+			}
+			if err != nil {
+				return nil, err
+			}
+			fh.Size = int(cr.n)
+			fh.store = o.FileStore
+			if err = consume(cr.n); err != nil {
+				fh.discard()
+				return nil, err
+			}
+			return fh, nil
+		}
+
+		var buff bytes.Buffer
+		n, err := io.CopyN(&buff, part, limitN(fileMaxMemory+1))
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		//未达到内存限制
+		if fileMaxMemory >= n {
+			if err = consume(n);err != nil {
+				return nil, err
+			}
+			fileMaxMemory -= n
+			fh.Size = int(n)
+			fh.content = buff.Bytes()
+			return fh, nil
+		}
+
+		//达到内存限制，将数据存入硬盘
+		var file *os.File
+		file, err = os.CreateTemp(o.TempDir, "multipart-")
+		if err != nil {
+			return nil, err
+		}
+		//将已经拷贝到buff里以及在part中还剩余的部分写入到硬盘
+		var src io.Reader = io.MultiReader(&buff, part)
+		if o.MaxTotalSize > 0 {
+			src = io.LimitReader(src,totalSize+1)
+		}
+		n, err = io.Copy(file, src)
+		if cerr := file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = consume(n)
+		}
+		if err != nil {
+			os.Remove(file.Name())
+			return nil, err
+		}
+		fh.Size = int(n)
+		fh.tmpFile = file.Name()
+		return fh, nil
+	}
+
+	var part *Part
+	parts := 0
 	for {
 		part,err = mr.NextPart()
 		if err == io.EOF {
@@ -108,86 +350,113 @@ func (mr *MultipartReader) ReadForm() (mf *MultipartForm,err error) {
 		if err != nil {
 			return
 		}
+		//只统计实际读到的part，恰好有MaxParts个part的表单是合法的
+		if parts++;parts > o.MaxParts {
+			return nil,ErrMessageTooLarge
+		}
 
 		if part.FormName() == "" {
 			continue
 		}
 
+		//RFC 2388中同一字段的多个文件以嵌套的multipart/mixed上传，每个子part都是一个文件
+		if part.FileName() == "" && part.isMultipart() {
+			var child *MultipartReader
+			if child, err = part.MultipartReader(); err != nil {
+				return
+			}
+			for {
+				var cp *Part
+				if cp, err = child.NextPart(); err == io.EOF {
+					err = nil
+					break
+				}
+				if err != nil {
+					return
+				}
+				if parts++; parts > o.MaxParts {
+					return nil, ErrMessageTooLarge
+				}
+				if cp.FileName() == "" {
+					continue
+				}
+				var fh *FileHeader
+				if fh, err = readFile(cp); err != nil {
+					return
+				}
+				form.File[part.FormName()] = append(form.File[part.FormName()], fh)
+			}
+			continue
+		}
+
 		var buff bytes.Buffer
 		var n int64
 		//non-file part
 		if part.FileName() == "" {
 			//copy的字节数未nonFileMaxMemory+1，好判断是否超过了内存大小限制
 			//如果err==io.EOF，则代表文本数据大小<nonFileMaxMemory+1，并未超过最大限制
-			n,err = io.CopyN(&buff,part,nonFileMaxMemory+1)
+			n,err = io.CopyN(&buff,part,limitN(nonFileMaxMemory+1))
 			if err != nil && err != io.EOF {
 				return
 			}
+			if err = consume(n);err != nil {
+				return
+			}
 			nonFileMaxMemory -= n
 			if nonFileMaxMemory < 0 {
-				return nil, errors.New("multipart: message too large")
+				return nil, ErrMessageTooLarge
 			}
-			mf.Value[part.FormName()] = buff.String()
+			form.Value[part.FormName()] = append(form.Value[part.FormName()], buff.String())
 			continue
 		}
 
 		//file part
-		n, err = io.CopyN(&buff, part, fileMaxMemory+1)
-		if err != nil && err != io.EOF {
+		var fh *FileHeader
+		if fh, err = readFile(part); err != nil {
 			return
 		}
-
-		fh := &FileHeader{
-			Filename: part.FileName(),
-			Header:   part.Header,
-		}
-
-		//未达到内存限制
-		if fileMaxMemory >= n {
-			fileMaxMemory -= n
-			fh.Size = int(n)
-			fh.content = buff.Bytes()
-			mf.File[part.FormName()] = fh
-			continue
+		form.File[part.FormName()] = append(form.File[part.FormName()], fh)
+	}
+	return form, nil
+}
+//硬盘上的暂时文件也应该在handler结束后删除，防止占用过多硬盘空间，我们提供一个将这些文件删除的方法。
+//存储后端中的文件归handler所有，不会删除
+func (mf *MultipartForm) RemoveAll() {
+	for _, fhs := range mf.File {
+		for _, fh := range fhs {
+			if fh != nil {
+				fh.remove()
+			}
 		}
+	}
+}
 
-		//达到内存限制，将数据存入硬盘
-		var file *os.File
-		file, err = os.CreateTemp("", "multipart-")
-		if err != nil {
-			return
-		}
-		//将已经拷贝到buff里以及在part中还剩余的部分写入到硬盘
-		n, err = io.Copy(file, io.MultiReader(&buff, part))
-		if cerr := file.Close(); cerr != nil {
-			err = cerr
-		}
-		if err != nil {
-			os.Remove(file.Name())
-			return
-		}
-		fh.Size = int(n)
-		fh.tmpFile = file.Name()
-		mf_, ok := mf.File[part.FormName()]
-		if ok {
-			os.Remove(mf_.tmpFile)
+//ReadForm出错时调用，连同已经写入存储后端的文件一起删除
+func (mf *MultipartForm) discard() {
+	for _, fhs := range mf.File {
+		for _, fh := range fhs {
+			if fh != nil {
+				fh.discard()
+			}
 		}
-		mf.File[part.FormName()] = fh
 	}
-	return mf, nil
 }
-//硬盘上的暂时文件也应该在handler结束后删除，防止占用过多硬盘空间，我们提供一个将这些文件删除的方法
-func (mf *MultipartForm) RemoveAll() {
-	for _, fh := range mf.File {
-		if fh == nil || fh.tmpFile == "" {
-			continue
-		}
+
+func (fh *FileHeader) remove() {
+	if fh.tmpFile != "" {
 		os.Remove(fh.tmpFile)
 	}
 }
 
+func (fh *FileHeader) discard() {
+	if fr, ok := fh.store.(FileRemover); ok {
+		fr.Remove(fh.key)
+	}
+	fh.remove()
+}
+
 func (p *Part) readHeader() (err error) {
-	p.Header, err = readHeader(p.mr.bufr)
+	p.Header, err = readHeaderLimit(p.mr.bufr, p.mr.maxHeaderBytes)
 	return err
 }
 
@@ -210,10 +479,23 @@ type Part struct {
 	//主要是为了方便引入io.LimiteReader来凝练我们的代码。
 	substituteReader io.Reader		//替补Reader
 	parsed           bool			//是否已经解析过formName以及fileName
+	index            int			//该part是报文中的第几个part，从0开始
+	bytesRead        int64			//已经读取的内容字节数
 }
 
 // https://www.gufeijun.com
 func (p *Part) Read(buf []byte) (n int, err error) {
+	n, err = p.read(buf)
+	if n > 0 {
+		p.bytesRead += int64(n)
+		if p.mr.progress != nil {
+			p.mr.progress(p, p.bytesRead)
+		}
+	}
+	return
+}
+
+func (p *Part) read(buf []byte) (n int, err error) {
 	if p.closed {
 		return 0, io.EOF
 	}
@@ -230,7 +512,7 @@ func (p *Part) Read(buf []byte) (n int, err error) {
 		//出现EOF错误，代表底层Reader已经没有足够的数据填满bufr的缓存，我们利用递归跳转到另一个if分支
 		if err == io.EOF {
 			p.mr.occurEofErr = true
-			return p.Read(buf)
+			return p.read(buf)
 		}
 		if err != nil {
 			return 0, err
@@ -269,28 +551,85 @@ func (p *Part) FileName() string {
 	return p.fileName
 }
 
+//Content-Disposition: form-data; name="file"; filename="a.txt"
+//参数值可能是带引号的字符串(其中可以出现;和=)，也可能是RFC 5987编码的filename*=UTF-8''%E4%B8%AD.txt
 func (p *Part) parseFormData() {
 	p.parsed = true
-	cd := p.Header.Get("Content-Disposition")
-	ss := strings.Split(cd, ";")
-	if len(ss) == 1 || strings.ToLower(ss[0]) != "form-data" {
+	disposition, params, err := parseHeaderParams(p.Header.Get("Content-Disposition"))
+	if err != nil {
 		return
 	}
-	for _, s := range ss {
-		key, value := getKV(s)
-		switch key {
-		case "name":
-			p.formName = value
-		case "filename":
-			p.fileName = value
+	//嵌套在multipart/mixed中的文件使用file或attachment，只有form-data才有字段名
+	if disposition == "form-data" {
+		p.formName = params["name"]
+	}
+	p.fileName = sanitizeFilename(params["filename"])
+}
+
+//Content-ID: <part1@example.com>，返回去掉尖括号后的值
+func (p *Part) ContentID() string {
+	return trimContentID(p.Header.Get("Content-ID"))
+}
+
+func trimContentID(id string) string {
+	id = strings.TrimSpace(id)
+	if len(id) >= 2 && id[0] == '<' && id[len(id)-1] == '>' {
+		id = id[1 : len(id)-1]
+	}
+	return id
+}
+
+//Content-Type为multipart/*的part本身也是一个multipart报文，如form-data中用multipart/mixed上传的多个文件，
+//MultipartReader将part的内容作为子报文读取，子报文读取结束后才能继续调用父报文的NextPart。
+//子报文继承父报文的首部大小限制和读取进度回调
+func (p *Part) MultipartReader() (*MultipartReader, error) {
+	mediaType, params, err := ParseMediaType(p.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	mr, err := newMultipartReaderFromType(p, mediaType, params)
+	if err != nil {
+		return nil, err
+	}
+	mr.maxHeaderBytes = p.mr.maxHeaderBytes
+	mr.progress = p.mr.progress
+	return mr, nil
+}
+
+func (p *Part) isMultipart() bool {
+	mediaType, _, err := ParseMediaType(p.Header.Get("Content-Type"))
+	return err == nil && strings.HasPrefix(mediaType, "multipart/")
+}
+
+//客户端上传的文件名不可信，可能是完整路径(IE会发送C:\dir\a.txt)或"../../etc/passwd"，只保留最后一级的文件名。
+//控制字符一并去掉，"evil.php\x00.jpg"这样的文件名在按C字符串处理的地方会被截断为evil.php
+func sanitizeFilename(name string) string {
+	//按字节处理，非UTF-8的文件名(之后可能按表单的字符集解码)保持不变
+	b := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c >= 0x20 && c != 0x7f {
+			b = append(b, c)
 		}
 	}
+	name = string(b)
+	if i := strings.LastIndexAny(name, `/\`); i != -1 {
+		name = name[i+1:]
+	}
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
 }
 
-func getKV(s string) (key string, value string) {
-	ss := strings.Split(s, "=")
-	if len(ss) != 2 {
-		return
+//返回part的Content-Type，按RFC 7578未设置时默认为text/plain
+func (p *Part) ContentType() string {
+	if ct := p.Header.Get("Content-Type"); ct != "" {
+		return ct
 	}
-	return strings.TrimSpace(ss[0]), strings.Trim(ss[1], `"`)
-}
\ No newline at end of file
+	return "text/plain"
+}
+
+//返回part的Content-Transfer-Encoding(小写)，如base64、quoted-printable，RFC 7578已不推荐使用，多数客户端不会设置
+func (p *Part) TransferEncoding() string {
+	return strings.ToLower(strings.TrimSpace(p.Header.Get("Content-Transfer-Encoding")))
+}
@@ -0,0 +1,181 @@
+package httpd
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//buildForm生成包含n个普通字段的表单
+func buildForm(t *testing.T, n int) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := NewMultipartWriter(&body)
+	for i := 0; i < n; i++ {
+		if err := mw.WriteField(fmt.Sprintf("f%d", i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, mw.Boundary()
+}
+
+func TestReadFormMaxParts(t *testing.T) {
+	const max = 3
+	for n, wantErr := range map[int]bool{max - 1: false, max: false, max + 1: true} {
+		body, boundary := buildForm(t, n)
+		form, err := NewMultipartReader(body, boundary).ReadForm(&MultipartOptions{MaxParts: max})
+		if wantErr {
+			if err != ErrMessageTooLarge {
+				t.Errorf("%d parts: err = %v, want %v", n, err, ErrMessageTooLarge)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d parts: %v", n, err)
+			continue
+		}
+		if len(form.Value) != n {
+			t.Errorf("%d parts: got %d values", n, len(form.Value))
+		}
+	}
+}
+
+//buildNestedForm生成一个字段名为files的part，其中以multipart/mixed嵌套了内容为contents的文件，
+//每个文件带有Content-MD5首部
+func buildNestedForm(t *testing.T, contents ...string) (*bytes.Buffer, string) {
+	t.Helper()
+	var inner bytes.Buffer
+	imw := NewMultipartWriter(&inner)
+	for i, content := range contents {
+		sum := md5.Sum([]byte(content))
+		h := make(Header)
+		h.Set("Content-Disposition", fmt.Sprintf(`file; filename="f%d.txt"`, i))
+		h.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		w, err := imw.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	imw.Close()
+
+	var body bytes.Buffer
+	mw := NewMultipartWriter(&body)
+	h := make(Header)
+	h.Set("Content-Disposition", `form-data; name="files"`)
+	h.Set("Content-Type", imw.ContentType("mixed"))
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(inner.Bytes())
+	mw.Close()
+	return &body, mw.Boundary()
+}
+
+//嵌套的multipart/mixed中，外层part和每个子part都计入MaxParts
+func TestReadFormMaxPartsNested(t *testing.T) {
+	body, boundary := buildNestedForm(t, "data", "data")
+
+	form, err := NewMultipartReader(bytes.NewReader(body.Bytes()), boundary).ReadForm(&MultipartOptions{MaxParts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer form.RemoveAll()
+	if len(form.File["files"]) != 2 {
+		t.Errorf("got %d files, want 2", len(form.File["files"]))
+	}
+
+	_, err = NewMultipartReader(bytes.NewReader(body.Bytes()), boundary).ReadForm(&MultipartOptions{MaxParts: 2})
+	if err != ErrMessageTooLarge {
+		t.Errorf("err = %v, want %v", err, ErrMessageTooLarge)
+	}
+}
+
+//嵌套的子part同样报告读取进度并计算摘要
+func TestReadFormNestedOptions(t *testing.T) {
+	body, boundary := buildNestedForm(t, "hello", "world!")
+	progress := make(map[string]int64)
+	form, err := NewMultipartReader(body, boundary).ReadForm(&MultipartOptions{
+		OnProgress: func(p *Part, n int64) {
+			if p.FileName() != "" {
+				progress[p.FileName()] = n
+			}
+		},
+		Digests:       []DigestAlgorithm{DigestSHA256},
+		VerifyDigests: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer form.RemoveAll()
+	if want := map[string]int64{"f0.txt": 5, "f1.txt": 6}; !reflect.DeepEqual(progress, want) {
+		t.Errorf("progress = %v, want %v", progress, want)
+	}
+	for _, fh := range form.File["files"] {
+		if len(fh.Digests[DigestSHA256]) == 0 || len(fh.Digests[DigestMD5]) == 0 {
+			t.Errorf("%s: digests %v", fh.Filename, fh.Digests)
+		}
+	}
+
+	//子part的内容与Content-MD5不一致
+	body, boundary = buildNestedForm(t, "hello")
+	tampered := bytes.Replace(body.Bytes(), []byte("hello"), []byte("jello"), 1)
+	_, err = NewMultipartReader(bytes.NewReader(tampered), boundary).ReadForm(&MultipartOptions{VerifyDigests: true})
+	if err != ErrDigestMismatch {
+		t.Errorf("err = %v, want %v", err, ErrDigestMismatch)
+	}
+}
+
+//首部的大小(每行加上\r\n，不含结束的空行)恰好等于上限时可以通过
+func TestReadHeaderLimitBoundary(t *testing.T) {
+	block := "Content-Disposition: form-data; name=\"a\"\r\nX-A: b\r\n"
+	size := len(block)
+	tests := []struct {
+		name  string
+		raw   string
+		limit int
+		err   error
+	}{
+		{"exact", block + "\r\n", size, nil},
+		{"one over", block + "\r\n", size - 1, errHeaderTooLarge},
+		{"one under", block + "\r\n", size + 1, nil},
+		//额度用完后只能是结束首部的空行
+		{"exact then another line", block + "X: y\r\n\r\n", size, errHeaderTooLarge},
+		{"exact then one byte", block + "X\r\n\r\n", size, errHeaderTooLarge},
+		{"exact then long line", block + "X-Long: " + strings.Repeat("a", 8192) + "\r\n\r\n", size, errHeaderTooLarge},
+		{"single line exact", "A: b\r\n\r\n", 6, nil},
+		{"single line over", "A: b\r\n\r\n", 5, errHeaderTooLarge},
+		{"unlimited", block + "\r\n", 0, nil},
+	}
+	for _, tt := range tests {
+		h, err := readHeaderLimit(newBufReader(tt.raw), tt.limit)
+		if err != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && h.Get("Content-Disposition") == "" && h.Get("A") == "" {
+			t.Errorf("%s: header %v", tt.name, h)
+		}
+	}
+
+	//经过MultipartReader时MaxPartHeaderBytes同样包含边界值
+	var body bytes.Buffer
+	mw := NewMultipartWriter(&body)
+	mw.WriteField("a", "v")
+	mw.Close()
+	partHeader := "Content-Disposition: form-data; name=\"a\"\r\n"
+	for limit, wantErr := range map[int]bool{len(partHeader): false, len(partHeader) - 1: true} {
+		_, err := NewMultipartReader(bytes.NewReader(body.Bytes()), mw.Boundary()).ReadForm(&MultipartOptions{MaxPartHeaderBytes: limit})
+		if (err != nil) != wantErr {
+			t.Errorf("MaxPartHeaderBytes %d: err = %v, want error %v", limit, err, wantErr)
+		}
+	}
+}
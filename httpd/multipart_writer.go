@@ -0,0 +1,204 @@
+package httpd
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+/**
+MultipartWriter按照MultipartReader能解析的格式生成multipart报文：
+--boundary\r\n首部\r\n\r\n内容\r\n--boundary\r\n...\r\n--boundary--\r\n
+可以用来构造multipart/form-data请求，也可以用来输出multipart/mixed、multipart/byteranges响应。
+ */
+type MultipartWriter struct {
+	w        io.Writer
+	boundary string
+	lastPart *partWriter
+}
+
+//使用随机生成的boundary
+func NewMultipartWriter(w io.Writer) *MultipartWriter {
+	return &MultipartWriter{
+		w:        w,
+		boundary: randomBoundary(),
+	}
+}
+
+func randomBoundary() string {
+	var buf [30]byte
+	if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%x", buf[:])
+}
+
+func (mw *MultipartWriter) Boundary() string {
+	return mw.boundary
+}
+
+//SetBoundary替换随机生成的boundary，必须在创建第一个part之前调用。
+//boundary长度为1~70，只能由RFC 2046规定的字符组成，且不能以空格结尾
+func (mw *MultipartWriter) SetBoundary(boundary string) error {
+	if mw.lastPart != nil {
+		return errors.New("multipart: SetBoundary called after write")
+	}
+	if len(boundary) < 1 || len(boundary) > 70 || boundary[len(boundary)-1] == ' ' {
+		return errors.New("multipart: invalid boundary length")
+	}
+	for i := 0; i < len(boundary); i++ {
+		c := boundary[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("'()+_,-./:=? ", c) != -1:
+		default:
+			return errors.New("multipart: invalid boundary character")
+		}
+	}
+	mw.boundary = boundary
+	return nil
+}
+
+//返回subtype对应的Content-Type，如ContentType("mixed")返回multipart/mixed; boundary=xxx
+func (mw *MultipartWriter) ContentType(subtype string) string {
+	b := mw.boundary
+	//boundary中含有tspecials时需要加上引号
+	for i := 0; i < len(b); i++ {
+		if !isTokenChar(b[i]) {
+			b = `"` + b + `"`
+			break
+		}
+	}
+	return "multipart/" + subtype + "; boundary=" + b
+}
+
+func (mw *MultipartWriter) FormDataContentType() string {
+	return mw.ContentType("form-data")
+}
+
+//CreatePart写入part的首部，返回用于写入part内容的Writer，创建下一个part或调用Close之后该Writer不可再用
+func (mw *MultipartWriter) CreatePart(header Header) (io.Writer, error) {
+	if mw.lastPart != nil {
+		if err := mw.lastPart.close(); err != nil {
+			return nil, err
+		}
+	}
+	var b strings.Builder
+	if mw.lastPart != nil {
+		b.WriteString("\r\n--" + mw.boundary + "\r\n")
+	} else {
+		b.WriteString("--" + mw.boundary + "\r\n")
+	}
+	//按字段名排序，保证输出稳定
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			b.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+	if _, err := io.WriteString(mw.w, b.String()); err != nil {
+		return nil, err
+	}
+	p := &partWriter{mw: mw}
+	mw.lastPart = p
+	return p, nil
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+//生成form-data的Content-Disposition，文件名含有非ASCII字符时额外提供RFC 5987编码的filename*
+func formDataDisposition(fieldname, filename string) string {
+	cd := `form-data; name="` + quoteEscaper.Replace(fieldname) + `"`
+	if filename == "" {
+		return cd
+	}
+	cd += `; filename="` + quoteEscaper.Replace(filename) + `"`
+	for i := 0; i < len(filename); i++ {
+		if filename[i] >= 0x80 {
+			cd += "; filename*=UTF-8''" + extValueEscape(filename)
+			break
+		}
+	}
+	return cd
+}
+
+//按RFC 5987的attr-char对s进行百分号编码
+func extValueEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) != -1 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func (mw *MultipartWriter) CreateFormFile(fieldname, filename string) (io.Writer, error) {
+	h := make(Header)
+	h.Set("Content-Disposition", formDataDisposition(fieldname, filename))
+	h.Set("Content-Type", "application/octet-stream")
+	return mw.CreatePart(h)
+}
+
+func (mw *MultipartWriter) CreateFormField(fieldname string) (io.Writer, error) {
+	h := make(Header)
+	h.Set("Content-Disposition", formDataDisposition(fieldname, ""))
+	return mw.CreatePart(h)
+}
+
+func (mw *MultipartWriter) WriteField(fieldname, value string) error {
+	p, err := mw.CreateFormField(fieldname)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(p, value)
+	return err
+}
+
+//Close结束最后一个part并写入结束分隔符\r\n--boundary--，不会关闭底层的Writer
+func (mw *MultipartWriter) Close() error {
+	if mw.lastPart != nil {
+		if err := mw.lastPart.close(); err != nil {
+			return err
+		}
+		mw.lastPart = nil
+		_, err := io.WriteString(mw.w, "\r\n--"+mw.boundary+"--\r\n")
+		return err
+	}
+	//没有任何part时只输出结束分隔符
+	_, err := io.WriteString(mw.w, "--"+mw.boundary+"--\r\n")
+	return err
+}
+
+type partWriter struct {
+	mw     *MultipartWriter
+	closed bool
+	we     error //写入过程中出现的错误
+}
+
+func (pw *partWriter) Write(p []byte) (n int, err error) {
+	if pw.closed {
+		return 0, errors.New("multipart: can't write to finished part")
+	}
+	n, err = pw.mw.w.Write(p)
+	if err != nil {
+		pw.we = err
+	}
+	return
+}
+
+func (pw *partWriter) close() error {
+	pw.closed = true
+	return pw.we
+}
@@ -0,0 +1,105 @@
+package httpd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+//用MultipartWriter生成报文，再用MultipartReader读回
+func TestMultipartWriterRoundTrip(t *testing.T) {
+	var body bytes.Buffer
+	mw := NewMultipartWriter(&body)
+	//含有空格和冒号的boundary在Content-Type中必须加引号
+	const boundary = "my boundary:1"
+	if err := mw.SetBoundary(boundary); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.WriteField("name", "value\r\nwith CRLF"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := mw.CreateFormFile("file", "报告 2024.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "file content")
+	if err = mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ct := mw.FormDataContentType()
+	if want := `multipart/form-data; boundary="my boundary:1"`; ct != want {
+		t.Errorf("content type = %q, want %q", ct, want)
+	}
+	mediaType, params, err := ParseMediaType(ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/form-data" || params["boundary"] != boundary {
+		t.Fatalf("parsed %q %q", mediaType, params)
+	}
+
+	mr := NewMultipartReader(&body, params["boundary"])
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.FormName() != "name" || p.FileName() != "" {
+		t.Errorf("part 1: name %q filename %q", p.FormName(), p.FileName())
+	}
+	if b, _ := ioutil.ReadAll(p); string(b) != "value\r\nwith CRLF" {
+		t.Errorf("part 1 content = %q", b)
+	}
+
+	p, err = mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//非ASCII文件名通过filename*传递
+	if !bytes.Contains([]byte(p.Header.Get("Content-Disposition")), []byte("filename*=UTF-8''")) {
+		t.Errorf("Content-Disposition = %q, want filename*", p.Header.Get("Content-Disposition"))
+	}
+	if p.FormName() != "file" || p.FileName() != "报告 2024.txt" {
+		t.Errorf("part 2: name %q filename %q", p.FormName(), p.FileName())
+	}
+	if b, _ := ioutil.ReadAll(p); string(b) != "file content" {
+		t.Errorf("part 2 content = %q", b)
+	}
+
+	if _, err = mr.NextPart(); err != io.EOF {
+		t.Errorf("NextPart after last part: %v, want EOF", err)
+	}
+}
+
+//没有任何part时Close只输出结束分隔符，读取方应直接得到EOF
+func TestMultipartWriterEmpty(t *testing.T) {
+	var body bytes.Buffer
+	mw := NewMultipartWriter(&body)
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "--" + mw.Boundary() + "--\r\n"; body.String() != want {
+		t.Errorf("body = %q, want %q", body.String(), want)
+	}
+	form, err := NewMultipartReader(&body, mw.Boundary()).ReadForm(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(form.Value) != 0 || len(form.File) != 0 {
+		t.Errorf("form = %+v, want empty", form)
+	}
+}
+
+func TestMultipartWriterSetBoundary(t *testing.T) {
+	for _, b := range []string{"", "trailing ", "bad\"quote", string(bytes.Repeat([]byte("a"), 71))} {
+		if err := NewMultipartWriter(ioutil.Discard).SetBoundary(b); err == nil {
+			t.Errorf("SetBoundary(%q) succeeded", b)
+		}
+	}
+	mw := NewMultipartWriter(ioutil.Discard)
+	mw.WriteField("a", "b")
+	if err := mw.SetBoundary("abc"); err == nil {
+		t.Error("SetBoundary after write succeeded")
+	}
+}
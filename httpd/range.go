@@ -0,0 +1,207 @@
+package httpd
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Range首部中的一个区间，已根据内容长度换算为绝对位置
+type httpRange struct {
+	start, length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(r.start, 10) + "-" + strconv.FormatInt(r.start+r.length-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
+
+var (
+	//Range首部格式错误，按RFC 9110忽略该首部，回复完整内容
+	errInvalidRange = errors.New("invalid range")
+	//所有区间都不在内容范围内，回复416
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+/**
+parseRange解析Range首部，size为内容的总长度：
+	bytes=0-499        前500字节
+	bytes=500-         从第500字节到末尾
+	bytes=-500         最后500字节
+	bytes=0-0,-1       第一个和最后一个字节
+超出末尾的区间会被截断，起点超出末尾的区间被忽略，全部被忽略时返回errUnsatisfiableRange。
+bytes=、bytes=,这种不含任何区间的首部格式错误，返回errInvalidRange
+*/
+func parseRange(s string, size int64) ([]httpRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return nil, errInvalidRange
+	}
+	var ranges []httpRange
+	parsed := false
+	for _, spec := range strings.Split(s[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.IndexByte(spec, '-')
+		if i == -1 {
+			return nil, errInvalidRange
+		}
+		parsed = true
+		first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+		var r httpRange
+		if first == "" {
+			//后缀区间：最后n个字节
+			n, err := parseRangeInt(last)
+			if err != nil {
+				return nil, errInvalidRange
+			}
+			if n > size {
+				n = size
+			}
+			//bytes=-0，或者内容为空
+			if n == 0 {
+				continue
+			}
+			r = httpRange{start: size - n, length: n}
+		} else {
+			start, err := parseRangeInt(first)
+			if err != nil {
+				return nil, errInvalidRange
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = parseRangeInt(last); err != nil || end < start {
+					return nil, errInvalidRange
+				}
+			}
+			if start >= size {
+				continue
+			}
+			if end >= size {
+				end = size - 1
+			}
+			r = httpRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+	if !parsed {
+		return nil, errInvalidRange
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+//只接受十进制数字，ParseInt会接受+号
+func parseRangeInt(s string) (int64, error) {
+	if s == "" {
+		return 0, errInvalidRange
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, errInvalidRange
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+//If-Range的值与当前内容一致时Range才生效，否则回复完整内容，避免客户端把新旧两个版本的片段拼在一起。
+//ETag必须强比较，日期必须与Last-Modified完全相同
+func checkIfRange(w ResponseWriter, r *Request, modtime time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		etag := w.Header().Get("ETag")
+		return etag != "" && !strings.HasPrefix(etag, "W/") && ir == etag
+	}
+	if modtime.IsZero() {
+		return false
+	}
+	t, err := time.Parse(TimeFormat, ir)
+	return err == nil && t.Unix() == modtime.Unix()
+}
+
+//multipart/byteranges响应的总长度，需要在写body之前作为Content-Length发出
+func rangesMIMESize(ranges []httpRange, boundary, ctype string, size int64) (int64, error) {
+	var cw countingWriter
+	mw := NewMultipartWriter(&cw)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, ra := range ranges {
+		if _, err := mw.CreatePart(rangeHeader(ra, ctype, size)); err != nil {
+			return 0, err
+		}
+		n += ra.length
+	}
+	if err := mw.Close(); err != nil {
+		return 0, err
+	}
+	return n + int64(cw), nil
+}
+
+func rangeHeader(ra httpRange, ctype string, size int64) Header {
+	h := make(Header)
+	h.Set("Content-Type", ctype)
+	h.Set("Content-Range", ra.contentRange(size))
+	return h
+}
+
+type countingWriter int64
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	*cw += countingWriter(len(p))
+	return len(p), nil
+}
+
+//按ranges回复内容，调用前需要确认ranges非空且都在size范围内
+func serveRanges(w ResponseWriter, r *Request, content io.ReadSeeker, ranges []httpRange, ctype string, size int64) {
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		if _, err := content.Seek(ra.start, io.SeekStart); err != nil {
+			serveError(w, StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Range", ra.contentRange(size))
+		w.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		w.WriteHeader(StatusPartialContent)
+		if r.Method != "HEAD" {
+			io.CopyN(w, content, ra.length)
+		}
+		return
+	}
+
+	//多个区间使用multipart/byteranges，每个part带有自己的Content-Type和Content-Range
+	mw := NewMultipartWriter(w)
+	length, err := rangesMIMESize(ranges, mw.Boundary(), ctype, size)
+	if err != nil {
+		serveError(w, StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mw.ContentType("byteranges"))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(StatusPartialContent)
+	if r.Method == "HEAD" {
+		return
+	}
+	for _, ra := range ranges {
+		part, err := mw.CreatePart(rangeHeader(ra, ctype, size))
+		if err != nil {
+			return
+		}
+		if _, err = content.Seek(ra.start, io.SeekStart); err != nil {
+			return
+		}
+		if _, err = io.CopyN(part, content, ra.length); err != nil {
+			return
+		}
+	}
+	mw.Close()
+}
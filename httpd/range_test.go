@@ -0,0 +1,227 @@
+package httpd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		size   int64
+		want   []httpRange
+		err    error
+	}{
+		{"bytes=0-4", 10, []httpRange{{0, 5}}, nil},
+		{"bytes=5-", 10, []httpRange{{5, 5}}, nil},
+		{"bytes=9-9", 10, []httpRange{{9, 1}}, nil},
+		{"bytes=-3", 10, []httpRange{{7, 3}}, nil},
+		{"bytes=0-0,-1", 10, []httpRange{{0, 1}, {9, 1}}, nil},
+		{"bytes= 0-1 , 3-4 ", 10, []httpRange{{0, 2}, {3, 2}}, nil},
+		{"bytes=0-1,,3-4", 10, []httpRange{{0, 2}, {3, 2}}, nil},
+		//超出末尾的区间被截断
+		{"bytes=8-20", 10, []httpRange{{8, 2}}, nil},
+		{"bytes=-20", 10, []httpRange{{0, 10}}, nil},
+		//起点超出末尾的区间被忽略
+		{"bytes=0-1,10-20", 10, []httpRange{{0, 2}}, nil},
+
+		{"bytes=5-4", 10, nil, errInvalidRange},
+		{"bytes=+1-2", 10, nil, errInvalidRange},
+		{"bytes=1-+2", 10, nil, errInvalidRange},
+		{"bytes=-+2", 10, nil, errInvalidRange},
+		{"bytes=0x1-2", 10, nil, errInvalidRange},
+		{"bytes=a-b", 10, nil, errInvalidRange},
+		{"bytes=1", 10, nil, errInvalidRange},
+		{"bytes=-", 10, nil, errInvalidRange},
+		{"bytes=0-1,x", 10, nil, errInvalidRange},
+		{"items=0-1", 10, nil, errInvalidRange},
+		{"Bytes=0-1", 10, nil, errInvalidRange},
+		{"bytes=99999999999999999999-", 10, nil, errInvalidRange},
+		//不含任何区间，格式错误而不是无法满足
+		{"bytes=", 10, nil, errInvalidRange},
+		{"bytes=,", 10, nil, errInvalidRange},
+		{"bytes= , ", 10, nil, errInvalidRange},
+
+		{"bytes=10-", 10, nil, errUnsatisfiableRange},
+		{"bytes=-0", 10, nil, errUnsatisfiableRange},
+		{"bytes=10-20,-0", 10, nil, errUnsatisfiableRange},
+		//内容为空时任何区间都无法满足
+		{"bytes=0-", 0, nil, errUnsatisfiableRange},
+		{"bytes=0-0", 0, nil, errUnsatisfiableRange},
+		{"bytes=-5", 0, nil, errUnsatisfiableRange},
+	}
+	for _, tt := range tests {
+		got, err := parseRange(tt.header, tt.size)
+		if err != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRange(%q, %d) = %v, %v; want %v, %v", tt.header, tt.size, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestCheckIfRange(t *testing.T) {
+	modtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	date := modtime.Format(TimeFormat)
+	tests := []struct {
+		name    string
+		etag    string
+		ifRange string
+		modtime time.Time
+		want    bool
+	}{
+		{"absent", `"v1"`, "", modtime, true},
+		{"strong match", `"v1"`, `"v1"`, modtime, true},
+		{"strong mismatch", `"v1"`, `"v2"`, modtime, false},
+		//If-Range只能使用强比较
+		{"weak if-range", `"v1"`, `W/"v1"`, modtime, false},
+		{"weak etag", `W/"v1"`, `W/"v1"`, modtime, false},
+		{"weak etag, strong if-range", `W/"v1"`, `"v1"`, modtime, false},
+		{"no etag", "", `"v1"`, modtime, false},
+		{"date match", `"v1"`, date, modtime, true},
+		{"date mismatch", `"v1"`, modtime.Add(time.Second).Format(TimeFormat), modtime, false},
+		{"date without modtime", `"v1"`, date, time.Time{}, false},
+		{"invalid date", `"v1"`, "yesterday", modtime, false},
+	}
+	for _, tt := range tests {
+		w := newRecorder()
+		if tt.etag != "" {
+			w.Header().Set("ETag", tt.etag)
+		}
+		r := newTestRequest("GET")
+		if tt.ifRange != "" {
+			r.Header.Set("If-Range", tt.ifRange)
+		}
+		if got := checkIfRange(w, r, tt.modtime); got != tt.want {
+			t.Errorf("%s: checkIfRange = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServeContentRange(t *testing.T) {
+	content := "0123456789"
+	tests := []struct {
+		name   string
+		method string
+		rng    string
+		status int
+		body   string
+		crange string
+	}{
+		{"no range", "GET", "", StatusOK, content, ""},
+		{"single", "GET", "bytes=2-4", StatusPartialContent, "234", "bytes 2-4/10"},
+		{"suffix", "GET", "bytes=-2", StatusPartialContent, "89", "bytes 8-9/10"},
+		{"open-ended clamped", "GET", "bytes=7-100", StatusPartialContent, "789", "bytes 7-9/10"},
+		{"HEAD", "HEAD", "bytes=2-4", StatusPartialContent, "", "bytes 2-4/10"},
+		{"unsatisfiable", "GET", "bytes=10-", StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"empty range set", "GET", "bytes=", StatusOK, content, ""},
+		{"invalid", "GET", "bytes=4-2", StatusOK, content, ""},
+		//重叠的区间总长度超过内容本身，回复完整内容
+		{"overlapping", "GET", "bytes=0-9,0-9", StatusOK, content, ""},
+		{"POST ignores range", "POST", "bytes=2-4", StatusOK, content, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newRecorder()
+			r := newTestRequest(tt.method)
+			if tt.rng != "" {
+				r.Header.Set("Range", tt.rng)
+			}
+			ServeContent(w, r, "a.txt", time.Time{}, strings.NewReader(content))
+			if w.code != tt.status {
+				t.Fatalf("status %d, want %d", w.code, tt.status)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.crange {
+				t.Errorf("Content-Range %q, want %q", got, tt.crange)
+			}
+			if tt.status == StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if w.body.String() != tt.body {
+				t.Errorf("body %q, want %q", w.body.String(), tt.body)
+			}
+			if w.Header().Get("Accept-Ranges") != "bytes" {
+				t.Errorf("Accept-Ranges %q", w.Header().Get("Accept-Ranges"))
+			}
+		})
+	}
+}
+
+//multipart/byteranges的Content-Length要在写body之前算出，必须与实际写出的长度一致
+func TestServeContentMultipleRanges(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	for _, rng := range []string{"bytes=0-0,-1", "bytes=0-9,20-29,90-", "bytes=5-5,50-59"} {
+		w := newRecorder()
+		ServeContent(w, newTestRequest("GET", "Range", rng), "a.txt", time.Time{}, strings.NewReader(content))
+		if w.code != StatusPartialContent {
+			t.Fatalf("%s: status %d", rng, w.code)
+		}
+		if cl := w.Header().Get("Content-Length"); cl != strconv.Itoa(w.body.Len()) {
+			t.Errorf("%s: Content-Length %s, body is %d bytes", rng, cl, w.body.Len())
+		}
+		mt, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		if err != nil || mt != "multipart/byteranges" {
+			t.Fatalf("%s: Content-Type %q", rng, w.Header().Get("Content-Type"))
+		}
+		ranges, _ := parseRange(rng, int64(len(content)))
+		mr := multipart.NewReader(bytes.NewReader(w.body.Bytes()), params["boundary"])
+		for i, ra := range ranges {
+			p, err := mr.NextPart()
+			if err != nil {
+				t.Fatalf("%s: part %d: %v", rng, i, err)
+			}
+			if got := p.Header.Get("Content-Range"); got != ra.contentRange(int64(len(content))) {
+				t.Errorf("%s: part %d Content-Range %q", rng, i, got)
+			}
+			if got := p.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+				t.Errorf("%s: part %d Content-Type %q", rng, i, got)
+			}
+			data, _ := ioutil.ReadAll(p)
+			if want := content[ra.start : ra.start+ra.length]; string(data) != want {
+				t.Errorf("%s: part %d = %q, want %q", rng, i, data, want)
+			}
+		}
+		if _, err = mr.NextPart(); err == nil {
+			t.Errorf("%s: extra part", rng)
+		}
+
+		//HEAD请求给出相同的Content-Length，但没有body
+		hw := newRecorder()
+		ServeContent(hw, newTestRequest("HEAD", "Range", rng), "a.txt", time.Time{}, strings.NewReader(content))
+		if hw.body.Len() != 0 || hw.Header().Get("Content-Length") != w.Header().Get("Content-Length") {
+			t.Errorf("%s: HEAD Content-Length %s, body %d bytes", rng, hw.Header().Get("Content-Length"), hw.body.Len())
+		}
+	}
+}
+
+func TestRangesMIMESize(t *testing.T) {
+	ranges := []httpRange{{0, 1}, {5, 3}, {99, 1}}
+	var buf bytes.Buffer
+	mw := NewMultipartWriter(&buf)
+	for _, ra := range ranges {
+		part, _ := mw.CreatePart(rangeHeader(ra, "text/plain", 100))
+		part.Write(make([]byte, ra.length))
+	}
+	mw.Close()
+	n, err := rangesMIMESize(ranges, mw.Boundary(), "text/plain", 100)
+	if err != nil || n != int64(buf.Len()) {
+		t.Errorf("rangesMIMESize = %d, %v; want %d", n, err, buf.Len())
+	}
+}
+
+//416经过连接发出时带有Content-Range: bytes */size
+func TestServeContentUnsatisfiableWire(t *testing.T) {
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		ServeContent(w, r, "a.txt", time.Time{}, strings.NewReader("0123456789"))
+	})
+	raw := serveRaw(t, h, "GET / HTTP/1.1\r\nHost: a\r\nRange: bytes=20-30\r\n\r\n")
+	resp, _ := parseResponse(t, raw, "GET")
+	if resp.StatusCode != StatusRequestedRangeNotSatisfiable || resp.Header.Get("Content-Range") != "bytes */10" {
+		t.Errorf("status %d, Content-Range %q", resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+}
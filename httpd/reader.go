@@ -2,6 +2,7 @@ package httpd
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"io"
 )
@@ -23,8 +24,24 @@ type chunkReader struct {
 func (cr *chunkReader)getChunkSize() (chunkSize int,err error) {
 	line,err := readLine(cr.bufr)
 	if err != nil{
+		//last-chunk之前连接就关闭了，body是不完整的
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return
 	}
+	//忽略块扩展，如"1a;name=value"
+	if i := bytes.IndexByte(line,';');i != -1 {
+		line = line[:i]
+	}
+	line = bytes.TrimRight(line," \t")
+	if len(line) == 0 {
+		return 0,errors.New("empty chunk size")
+	}
+	//块长度过大会使chunkSize溢出成负数，从而错判body的边界
+	if len(line) > 15 {
+		return 0,errors.New("chunk size too large")
+	}
 
 	//chunk编码长度为16进制，此处需要转换为10进制
 	for i:=0;i < len(line);i++ {
@@ -42,8 +59,25 @@ func (cr *chunkReader)getChunkSize() (chunkSize int,err error) {
 	return
 }
 
+//最后一个块之后可能跟着trailer首部，需要一直读到空行为止，否则残留的trailer会被当作下一个请求解析
+func (cr *chunkReader) discardTrailer() (err error) {
+	for {
+		line,err := readLine(cr.bufr)
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			return nil
+		}
+	}
+}
+
 func (cr *chunkReader) discardCRLF() (err error){
-	if _, err = io.ReadFull(cr.bufr, cr.crlf[:]); err == nil {
+	_, err = io.ReadFull(cr.bufr, cr.crlf[:])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
 		if cr.crlf[0] != '\r' || cr.crlf[1] != '\n' {
 			return errors.New("unsupported encoding format of chunk")
 		}
@@ -53,7 +87,7 @@ func (cr *chunkReader) discardCRLF() (err error){
 
 func (cr *chunkReader)Read(p []byte)(n int,err error){
 	if cr.done {
-		return
+		return 0,io.EOF
 	}
 
 	if cr.n == 0 {
@@ -65,23 +99,26 @@ func (cr *chunkReader)Read(p []byte)(n int,err error){
 
 	if cr.n == 0 {
 		cr.done = true
-		err = cr.discardCRLF()
+		err = cr.discardTrailer()
+		if err == nil {
+			err = io.EOF
+		}
 		return
 	}
 
-	//如果当前块剩余的数据大于p的长度
-	if len(p) <= cr.n {
-		n,err = cr.bufr.Read(p)
-		cr.n -= n
-		return n,err
+	//最多只读到当前块的末尾
+	if len(p) > cr.n {
+		p = p[:cr.n]
 	}
-
-	//如果当前块剩余的数据不够p的长度
-	n,_ = io.ReadFull(cr.bufr,p[:cr.n])
-	cr.n = 0
-	//将\r\n从流中消费掉
-	if err = cr.discardCRLF();err != nil {
-		return
+	n,err = cr.bufr.Read(p)
+	cr.n -= n
+	//块还没读完连接就关闭了，不能当作body正常结束
+	if err == io.EOF && cr.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	//当前块读完后，将\r\n从流中消费掉，否则下一次会把空行当作块长度解析
+	if err == nil && cr.n == 0 {
+		err = cr.discardCRLF()
 	}
 	return
 }
@@ -100,4 +137,64 @@ func (er *expectContinueReader) Read(p []byte)(n int,err error){
 		er.wroteContinue = true
 	}
 	return er.r.Read(p)
-}
\ No newline at end of file
+}
+//body超过MaxBytesReader设定的上限时返回该错误
+type MaxBytesError struct {
+	Limit int64
+}
+
+func (e *MaxBytesError) Error() string {
+	return "httpd: request body too large"
+}
+
+//MaxBytesReader限制从r中最多读取n个字节，超出时返回*MaxBytesError。
+//同时会通知w所属的response：回复413并在响应结束后关闭连接，剩余的body不再读取。
+//w被中间件包装过时沿着Unwrap方法找到内部的response，找不到时只返回错误
+func MaxBytesReader(w ResponseWriter,r io.Reader,n int64) io.Reader {
+	return newMaxBytesReader(unwrapResponse(w),r,n)
+}
+
+func newMaxBytesReader(resp *response,r io.Reader,n int64) *maxBytesReader {
+	return &maxBytesReader{resp: resp,r: r,limit: n}
+}
+
+type maxBytesReader struct {
+	resp  *response	//超限时通知的response，可以为nil
+	r     io.Reader
+	limit int64 //上限，小于等于0表示不限制
+	read  int64 //已经读取的字节数
+	err   error
+}
+
+func (l *maxBytesReader) Read(p []byte) (n int,err error) {
+	if l.err != nil {
+		return 0,l.err
+	}
+	if l.limit <= 0 {
+		n,err = l.r.Read(p)
+		l.read += int64(n)
+		return
+	}
+	//多读一个字节，用来判断body是否超过了上限
+	if remaining := l.limit - l.read + 1;remaining < int64(len(p)) {
+		if remaining < 0 {
+			remaining = 0
+		}
+		p = p[:remaining]
+	}
+	n,err = l.r.Read(p)
+	l.read += int64(n)
+	if l.read <= l.limit {
+		return n,err
+	}
+
+	n -= int(l.read - l.limit)
+	if n < 0 {
+		n = 0
+	}
+	l.err = &MaxBytesError{Limit: l.limit}
+	if l.resp != nil {
+		l.resp.requestTooLarge()
+	}
+	return n,l.err
+}
@@ -0,0 +1,102 @@
+package httpd
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func newBufReader(s string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(s))
+}
+
+func TestChunkReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		rest    string
+		wantErr bool
+		err     error
+	}{
+		{name: "simple", raw: "3\r\nabc\r\n2\r\nde\r\n0\r\n\r\nnext", want: "abcde", rest: "next"},
+		{name: "extension", raw: "3;a=b\r\nabc\r\n0\r\n\r\n", want: "abc"},
+		{name: "trailer", raw: "3\r\nabc\r\n0\r\nX-T: v\r\n\r\nGET", want: "abc", rest: "GET"},
+		{name: "truncated data", raw: "5\r\nab", err: io.ErrUnexpectedEOF},
+		{name: "missing CRLF after data", raw: "3\r\nabc", err: io.ErrUnexpectedEOF},
+		{name: "missing last chunk", raw: "3\r\nabc\r\n", err: io.ErrUnexpectedEOF},
+		{name: "truncated size line", raw: "3\r\nabc\r\n0", err: io.ErrUnexpectedEOF},
+		{name: "bare LF size line", raw: "3\nabc\r\n0\r\n\r\n", wantErr: true},
+		{name: "bare LF trailer", raw: "0\r\nX-T: v\n\r\n", wantErr: true},
+		{name: "bad data terminator", raw: "3\r\nabcX\n0\r\n\r\n", wantErr: true},
+		{name: "empty size", raw: "\r\nabc\r\n", wantErr: true},
+		{name: "illegal hex", raw: "3g\r\nabc\r\n", wantErr: true},
+		{name: "size overflow", raw: "ffffffffffffffff\r\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bufr := newBufReader(tt.raw)
+			got, err := ioutil.ReadAll(&chunkReader{bufr: bufr})
+			switch {
+			case tt.err != nil:
+				if err != tt.err {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+			case tt.wantErr:
+				if err == nil {
+					t.Fatalf("got %q, want error", got)
+				}
+			default:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != tt.want {
+					t.Errorf("body = %q, want %q", got, tt.want)
+				}
+				//last-chunk和trailer之后的数据属于下一个请求，不能被吃掉
+				if rest, _ := ioutil.ReadAll(bufr); string(rest) != tt.rest {
+					t.Errorf("rest = %q, want %q", rest, tt.rest)
+				}
+			}
+		})
+	}
+}
+
+func TestReadLineLimit(t *testing.T) {
+	tests := []struct {
+		raw     string
+		max     int
+		want    string
+		wantErr error
+	}{
+		{raw: "abc\r\n", want: "abc"},
+		{raw: "\r\n", want: ""},
+		{raw: "abc\r\n", max: 3, want: "abc"},
+		{raw: "abcd\r\n", max: 3, wantErr: errHeaderTooLarge},
+		{raw: "abc\n", wantErr: badRequestError("line not terminated by CRLF")},
+		{raw: "a\rbc\r\n", wantErr: badRequestError("bare CR in line")},
+		{raw: "abc", wantErr: io.ErrUnexpectedEOF},
+		{raw: "", wantErr: io.EOF},
+	}
+	for _, tt := range tests {
+		got, err := readLineLimit(newBufReader(tt.raw), tt.max)
+		if err != tt.wantErr {
+			t.Errorf("readLineLimit(%q, %d) err = %v, want %v", tt.raw, tt.max, err, tt.wantErr)
+			continue
+		}
+		if err == nil && string(got) != tt.want {
+			t.Errorf("readLineLimit(%q, %d) = %q, want %q", tt.raw, tt.max, got, tt.want)
+		}
+	}
+}
+
+//超过bufio缓存大小的行也要完整读出
+func TestReadLineLimitLongLine(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	got, err := readLineLimit(bufio.NewReaderSize(strings.NewReader(long+"\r\n"), 16), 0)
+	if err != nil || string(got) != long {
+		t.Errorf("got %d bytes, err %v", len(got), err)
+	}
+}
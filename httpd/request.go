@@ -3,16 +3,25 @@ package httpd
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/textproto"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
 )
 
+//请求报文格式有误(如存在请求走私的嫌疑)时返回该错误，conn会回复400并关闭连接
+type badRequestError string
+
+func (e badRequestError) Error() string {
+	return "bad request: " + string(e)
+}
+
 type Request struct {
 	Method string	//请求方法，如POST、GET
 	Url *url.URL	//Url
@@ -22,27 +31,72 @@ type Request struct {
 	RemoteAddr string	//客户端地址
 	RequestURI	string	//字符串形式的url
 	conn *conn
-	cookies map[string]string	//存储cookies
+	cookies []*Cookie	//存储cookies
 	queryString map[string]string //存储查询字符串
 	contentType string
+	contentTypeParams map[string]string
 	boundary string
 
 	postForm map[string]string
+	postFormValues url.Values	//urlencoded表单的全部值，供Bind使用
 	multipartForm *MultipartForm
+	multipartOptions *MultipartOptions
+	resp *response
+	pathParams map[string]string	//路由中{name}匹配到的路径参数
+	queryValues url.Values		//按标准格式解码的查询字符串，供Bind使用
 	haveParsedForm	bool
 	parseFormErr error
+	ctx context.Context
 }
 //公共方法获取查询字符串
 func (r *Request) Query(name string) string{
 	return r.queryString[name]
 }
 
+//返回路由/users/{id}中{id}匹配到的路径段
+func (r *Request) PathValue(name string) string{
+	return r.pathParams[name]
+}
+
+//返回请求的context，未设置时为context.Background()。中间件可以通过WithContext在其中附加数据，如sessions中的会话
+func (r *Request) Context() context.Context{
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+//WithContext返回r的浅拷贝，其context替换为ctx。拷贝与r共享body和连接，handler应只使用其中一个读取body
+func (r *Request) WithContext(ctx context.Context) *Request{
+	if ctx == nil {
+		panic("httpd: nil context")
+	}
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+	return r2
+}
+
 func (r *Request) Cookie(name string) string{
 	//cookie采用懒加载方式，使用时再分配能存及处理，不使用则不处理，提高性能
 	if r.cookies == nil {
 		r.parseCookies()
 	}
-	return r.cookies[name]
+	//同名cookie(如不同Path下设置的)可能有多个，浏览器会把Path更具体的排在前面，因此取第一个
+	for _,c := range r.cookies {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+//返回请求中的所有cookie，同名的cookie按出现顺序全部保留
+func (r *Request) Cookies() []*Cookie{
+	if r.cookies == nil {
+		r.parseCookies()
+	}
+	return r.cookies
 }
 
 func (r *Request) parseQuery() {
@@ -62,48 +116,57 @@ func parseQuery(rawQuery string) map[string]string{
 	return queries
 }
 
+//Cookie: a=1; b="2"; a=3
+//名称或值不合法的cookie直接忽略，不影响其它cookie
 func (r *Request) parseCookies() {
 	if r.cookies != nil{
 		return
 	}
 
-	r.cookies = make(map[string]string)
-	rawCookies,ok := r.Header["Cookie"]
-	if !ok{
-		return
-	}
-
-	for _,line := range rawCookies{
-		kvs := strings.Split(strings.TrimSpace(line),";")
-		if len(kvs) == 1 && kvs[0] == ""{
-			continue
-		}
-
-		for i := 0;i < len(kvs);i++{
-			index := strings.IndexByte(kvs[i],'=')
+	r.cookies = []*Cookie{}
+	for _,line := range r.Header["Cookie"]{
+		for _,kv := range strings.Split(line,";"){
+			kv = strings.TrimSpace(kv)
+			index := strings.IndexByte(kv,'=')
 			if index == -1 {
 				continue
 			}
-			r.cookies[strings.TrimSpace(kvs[i][:index])] = strings.TrimSpace(kvs[i][index+1:])
+			name,value := kv[:index],kv[index+1:]
+			//cookie的值可以用双引号括起来，引号不属于值的一部分
+			if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = value[1:len(value)-1]
+			}
+			if !isCookieName(name) || !isCookieValue(value) {
+				continue
+			}
+			r.cookies = append(r.cookies,&Cookie{Name: name,Value: value})
 		}
- 	}
-
-	return
+	}
 }
 //body的长度是个重要的问题，需要正确的读取，尤其是keep-alive情况下，不能出现超范围读取的情况
-func (r *Request)setupBody(){
-	//POST和PUT之外的请求不允许设置报文主体
-	if r.Method != "POST" && r.Method != "PUT" {
-		r.Body = &eofReader{}
-		return
+//如果前端代理与我们对body边界的判断不一致，攻击者就可以把第二个请求"走私"到第一个请求的body中，
+//因此凡是有歧义的报文(同时存在TE和CL、多个不同的CL、无法识别的传输编码等)，一律返回badRequestError，由conn回复400并关闭连接
+func (r *Request)setupBody() error{
+	chunked,err := r.parseTransferEncoding()
+	if err != nil {
+		return err
+	}
+	contentLength,err := r.parseContentLength()
+	if err != nil {
+		return err
+	}
+	if chunked && contentLength != -1 {
+		return badRequestError("both Transfer-Encoding and Content-Length present")
 	}
 
-	if r.chunked() {
+	//body的边界对所有方法都按照TE/CL来界定，PATCH、DELETE等方法同样可以携带body。
+	//GET、HEAD请求的body按RFC 9110没有约定的语义，但仍要按边界读出，否则残留在连接上的数据会被当作下一个请求解析
+	if chunked {
 		r.Body = &chunkReader{
 			bufr: r.conn.bufr,
 		}
 		r.fixExpectContinueReader()
-		return
+		return nil
 	}
 	/*
 	Content-Length 字段必须真实反映实体长度，但实际应用中，有些时候实体长度并没那么好获得，例如实体来自于网络文件，或者由动态语言生成。
@@ -111,17 +174,10 @@ func (r *Request)setupBody(){
 	但在 HTTP 报文中，实体一定要在头部之后，顺序不能颠倒，
 	为此我们需要一个新的机制：不依赖头部的长度信息，也能知道实体的边界。然后内容可以分块逐步传输。Transfer-Encoding: chunked
 	 */
-	cl := r.Header.Get("Content-Length")
 	//读取不到报文长度无法界定body，也要返回eofReader
-	if cl == "" {
-		r.Body = &eofReader{}
-		return
-	}
-
-	contentLength,err := strconv.ParseInt(cl,10,64)
-	if err != nil{
+	if contentLength == -1 {
 		r.Body = &eofReader{}
-		return
+		return nil
 	}
 
 	r.Body = &io.LimitedReader{
@@ -129,6 +185,82 @@ func (r *Request)setupBody(){
 		N: contentLength,
 	}
 	r.fixExpectContinueReader()
+	return nil
+}
+
+//解析Transfer-Encoding，返回body是否采用chunk编码。
+//我们只支持chunked这一种传输编码，且chunked必须是最后一个编码，否则无法确定body的边界；
+//HTTP/1.0没有Transfer-Encoding，收到时同样视为有歧义的报文
+func (r *Request) parseTransferEncoding() (chunked bool,err error) {
+	values,ok := r.Header["Transfer-Encoding"]
+	if !ok {
+		return false,nil
+	}
+	if r.Proto == "HTTP/1.0" {
+		return false,badRequestError("Transfer-Encoding in HTTP/1.0 request")
+	}
+
+	var codings []string
+	for _,v := range values {
+		for _,s := range strings.Split(v,",") {
+			//列表中允许出现空元素，如"chunked, ,"
+			if s = strings.ToLower(strings.TrimSpace(s));s != "" {
+				codings = append(codings,s)
+			}
+		}
+	}
+	if len(codings) == 0 {
+		return false,badRequestError("empty Transfer-Encoding")
+	}
+	for i,coding := range codings {
+		if coding != "chunked" {
+			return false,badRequestError("unsupported transfer coding " + strconv.Quote(coding))
+		}
+		if i != len(codings)-1 {
+			return false,badRequestError("chunked is not the final transfer coding")
+		}
+	}
+	return true,nil
+}
+
+//解析Content-Length，未设置时返回-1。
+//允许重复出现相同的值(如"Content-Length: 5, 5")，但不同的值、负数、"+5"这类ParseInt能接受的写法都视为非法
+func (r *Request) parseContentLength() (int64,error) {
+	values,ok := r.Header["Content-Length"]
+	if !ok {
+		return -1,nil
+	}
+
+	var contentLength int64 = -1
+	for _,v := range values {
+		for _,s := range strings.Split(v,",") {
+			n,err := parseContentLength(strings.TrimSpace(s))
+			if err != nil {
+				return -1,err
+			}
+			if contentLength != -1 && contentLength != n {
+				return -1,badRequestError("conflicting Content-Length values")
+			}
+			contentLength = n
+		}
+	}
+	return contentLength,nil
+}
+
+func parseContentLength(s string) (int64,error) {
+	if s == "" {
+		return 0,badRequestError("empty Content-Length")
+	}
+	for i := 0;i < len(s);i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0,badRequestError("invalid Content-Length " + strconv.Quote(s))
+		}
+	}
+	n,err := strconv.ParseInt(s,10,64)
+	if err != nil {
+		return 0,badRequestError("invalid Content-Length " + strconv.Quote(s))
+	}
+	return n,nil
 }
 //为了防止资源的浪费，有些客户端在发送完http首部之后，发送body数据前，会先通过发送Expect: 100-continue查询服务端是否希望接受body数据，
 //服务端只有回复了HTTP/1.1 100 Continue客户端才会再次发送body。因此我们也要处理这种情况：
@@ -142,19 +274,15 @@ func (r *Request) fixExpectContinueReader() {
 	}
 }
 
-func (r *Request)chunked() bool{
-	te := r.Header.Get("Transfer-Encoding")
-	return te == "chunked"
-}
-
 /**
 	如果用户Handler中没有去读取Body的数据，就意味着处理同一个socket连接上的下一个http报文时，Body未消费的数据会干扰下一个报文的解析。
 	所以我们的框架还需要在Handler结束后，将当前http请求的数据给消费掉。给Request增加一个finishRequest方法，以后的一些善尾工作都将交给它：
  */
 func (r *Request) finishRequest(resp *response) (err error){
 	//用户获取MultipartForm之后，应该调用RemoveAll方法将暂存的文件删除。用户可能在Handler中忘记调用RemoveALL，因此我们在Request的finishRequest方法中做出防备
-	if r.multipartForm != nil{
-		r.multipartForm.RemoveAll()
+	//handler拿到的可能是WithContext产生的拷贝，表单解析在拷贝上进行，因此暂存文件记录在response中统一删除
+	for _,form := range resp.multipartForms {
+		form.RemoveAll()
 	}
 	//告诉chunkWriter handler已经结束
 	resp.handlerDone = true
@@ -171,8 +299,12 @@ func (r *Request) finishRequest(resp *response) (err error){
 	}
 
 	//如果用户的handler中未Write任何数据，我们手动触发(*chunkWriter).writeHeader
+	//HEAD请求的响应没有body，但handler设置的Content-Length表示GET时body的长度，应当保留；
+	//304等不允许有body的响应不需要Content-Length，304中的Content-Length会被当作资源的长度
 	if !resp.cw.wrote {
-		resp.header.Set("Content-Length","0")
+		if bodyAllowedForStatus(resp.statusCode) && (r.Method != "HEAD" || resp.header.Get("Content-Length") == "") {
+			resp.header.Set("Content-Length","0")
+		}
 		if err = resp.cw.writeHeader(); err != nil {
 			return
 		}
@@ -181,18 +313,32 @@ func (r *Request) finishRequest(resp *response) (err error){
 	if err = r.conn.bufw.Flush();err!=nil{
 		return
 	}
+	//连接即将关闭时没有必要再消费剩余的数据，body可能超出了大小限制，读取它只会浪费资源
+	if resp.closeAfterReply {
+		return nil
+	}
 	//消费掉剩余的数据
 	_,err = io.Copy(ioutil.Discard,r.Body)
 	return err
 }
 //文件的读取还是比较麻烦，用户还需要对MultipartForm的具体结构进行了解才能使用。我们对其简化：
+//字段对应多个文件时返回第一个
 func (r *Request) FormFile(key string)(fh* FileHeader,err error){
+	fhs,err := r.FormFiles(key)
+	if err!=nil{
+		return
+	}
+	return fhs[0],nil
+}
+
+//返回字段对应的所有文件
+func (r *Request) FormFiles(key string)(fhs []*FileHeader,err error){
 	mf,err := r.MultipartForm()
 	if err!=nil{
 		return
 	}
-	fh,ok:=mf.File[key]
-	if !ok{
+	fhs,ok:=mf.File[key]
+	if !ok || len(fhs) == 0{
 		return nil,errors.New("http: missing multipart file")
 	}
 	return
@@ -205,6 +351,8 @@ func readRequest(c *conn) (*Request,error) {
 	r := new(Request)
 	r.conn = c
 	r.RemoteAddr = c.rawConn.RemoteAddr().String()
+	//keep-alive连接上的每个请求都要重新限制首部字节数，上一个请求读body时已将其置为不限制
+	c.limitR.N = 1<<20
 	//读出第一行，如Get /index?name=gu HTTP/1.1
 	line,err := readLine(c.bufr)
 	if err != nil{
@@ -232,81 +380,141 @@ func readRequest(c *conn) (*Request,error) {
 	const noLimit = (1 << 63)-1
 	r.conn.limitR.N = noLimit		//body的读取无需进行读取字符数限制
 	//设置body
-	r.setupBody()
+	if err = r.setupBody();err != nil {
+		return nil,err
+	}
 	return r,nil
 }
 
+var errHeaderTooLarge = errors.New("header too large")
+
 func readLine(bufr *bufio.Reader) ([]byte,error){
-	p,isPrefix,err := bufr.ReadLine()
-	if err != nil{
-		return p,err
-	}
+	return readLineLimit(bufr,0)
+}
 
-	var l []byte
-	for isPrefix {
-		l,isPrefix,err = bufr.ReadLine()
-		if err != nil{
-			break
+//readLineLimit读取一行，行的长度超过max时返回errHeaderTooLarge，max小于等于0表示不限制。
+//bufr.ReadSlice每次最多返回缓存大小的数据，所以超长的行在拼接过程中就会被发现，不会耗尽内存。
+//行必须以\r\n结尾，单独的\n或\r在不同实现中会被解读成不同的行边界，是请求走私的常见手段，一律视为badRequestError
+func readLineLimit(bufr *bufio.Reader,max int) ([]byte,error){
+	var line []byte
+	for {
+		frag,err := bufr.ReadSlice('\n')
+		//行尾的\r\n不计入长度
+		if max > 0 && len(line)+len(frag) > max+2 {
+			return nil,errHeaderTooLarge
+		}
+		line = append(line,frag...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			//连接在一行的中间关闭
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil,err
 		}
-		p = append(p,l...)
+		break
 	}
-
-	return p,err
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil,badRequestError("line not terminated by CRLF")
+	}
+	line = line[:len(line)-2]
+	if bytes.IndexByte(line,'\r') != -1 {
+		return nil,badRequestError("bare CR in line")
+	}
+	return line,nil
 }
 
 func readHeader(bufr *bufio.Reader) (Header,error){
+	return readHeaderLimit(bufr,0)
+}
+
+//readHeaderLimit读取首部，首部的总字节数超过maxBytes时返回errHeaderTooLarge，maxBytes小于等于0表示不限制
+func readHeaderLimit(bufr *bufio.Reader,maxBytes int) (Header,error){
 	header := make(Header)
+	remaining := maxBytes
 	for {
-		line,err := readLine(bufr)
+		limit := remaining
+		//额度恰好用完时只能再读到结束首部的空行，readLineLimit的max为0表示不限制，不能直接传入
+		if maxBytes > 0 && limit == 0 {
+			limit = 1
+		}
+		line,err := readLineLimit(bufr,limit)
 		if err != nil{
 			return nil,err
 		}
+		if maxBytes > 0 {
+			//将行尾的\r\n也计算在内，结束首部的空行不计
+			if remaining -= len(line) + 2;remaining < 0 && len(line) != 0 {
+				return nil,errHeaderTooLarge
+			}
+		}
 		//如果读到/r/n/r/n，代表报文首部结束
 		//readLine方法返回换行符之前的行内容，空行自然没有长度
 		if len(line) == 0{
 			break
 		}
-		//example：Connection :keep-alive
+		//以空白开头的行是已废弃的折行写法(obs-fold)，不同实现对它的处理不一致，直接拒绝
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil,badRequestError("obsolete line folding in header")
+		}
+		//example：Connection: keep-alive
 		i := bytes.IndexByte(line,':')
 		if i == -1{
 			return nil,errors.New("unsupported protocol")
 		}
-
-		if i == len(line)-1 {
-			continue
+		//字段名与冒号之间不允许有空白，否则"Transfer-Encoding : chunked"这类首部会被各方解读得不一样
+		if i == 0 || bytes.IndexAny(line[:i]," \t") != -1 {
+			return nil,badRequestError("invalid header field name " + strconv.Quote(string(line[:i])))
 		}
-		k,v := string(line[:i]),strings.TrimSpace(string(line[i+1:]))
+
+		//值为空的字段也要保留，"Content-Length:"被忽略的话body会被当作空，其后的数据就成了下一个请求
+		k,v := textproto.CanonicalMIMEHeaderKey(string(line[:i])),strings.TrimSpace(string(line[i+1:]))
 		header[k] = append(header[k],v)
 	}
 	return header,nil
 }
 
+//Content-Type: multipart/form-data; boundary=------974767299852498929531610575
+//Content-Type: multipart/related; type="application/json"; start="<root>"; boundary=abc
+//Content-Type: application/x-www-form-urlencoded
 func (r *Request)parseContentType(){
-	ct := r.Header.Get("Content-Type")
-	//Content-Type: multipart/form-data; boundary=------974767299852498929531610575
-	//Content-Type: application/x-www-form-urlencoded
-	index := strings.IndexByte(ct,';')
-	if index == -1 {
-		r.contentType = ct
-	}
-
-	if index == len(ct)-1 {
+	mediaType,params,err := ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
 		return
 	}
+	r.contentType,r.contentTypeParams = mediaType,params
+	if strings.HasPrefix(mediaType,"multipart/") {
+		r.boundary = params["boundary"]
+	}
+}
 
-	ss := strings.Split(ct[index+1:],"=")
-	if len(ss) < 2 || strings.TrimSpace(ss[0]) != "boundary" {
-		return
+//返回小写的媒体类型，如application/json，Content-Type缺失或无法解析时为空
+func (r *Request) MediaType() string {
+	return r.contentType
+}
+
+//返回Content-Type中的charset参数(小写)，如utf-8，未设置时为空
+func (r *Request) Charset() string {
+	return strings.ToLower(r.contentTypeParams["charset"])
+}
+
+//返回Content-Type中的所有参数，参数名为小写。返回的是副本，修改它不会影响Request
+func (r *Request) ContentTypeParams() map[string]string {
+	params := make(map[string]string,len(r.contentTypeParams))
+	for k,v := range r.contentTypeParams {
+		params[k] = v
 	}
-	r.contentType,r.boundary = ct[:index],strings.Trim(ss[1],"=")
-	return
+	return params
 }
 
+//支持所有multipart/*类型的body，如multipart/form-data、multipart/mixed、multipart/related
 func (r *Request) MultipartReader()(*MultipartReader,error){
 	if r.boundary==""{
 		return nil,errors.New("no boundary detected")
 	}
-	return NewMultipartReader(r.Body,r.boundary),nil
+	return newMultipartReaderFromType(r.Body,r.contentType,r.contentTypeParams)
 }
 
 func (r *Request) PostForm(name string) string {
@@ -329,8 +537,34 @@ func (r *Request) MultipartForm() (*MultipartForm,error) {
 	return r.multipartForm,r.parseFormErr
 }
 
+//ParseForm解析urlencoded或multipart表单，返回解析过程中出现的错误。
+//PostForm、MultipartForm在首次调用时也会自动解析，但PostForm会忽略错误
+func (r *Request) ParseForm() error {
+	if !r.haveParsedForm {
+		r.parseFormErr = r.parseForm()
+	}
+	return r.parseFormErr
+}
+
+//ParseMultipartForm使用指定的配置解析multipart表单，opts为nil时使用默认配置。
+//需要在PostForm、MultipartForm、FormFile之前调用，否则表单已经按默认配置解析过，opts不再生效
+func (r *Request) ParseMultipartForm(opts *MultipartOptions) error {
+	if r.haveParsedForm {
+		return r.parseFormErr
+	}
+	if r.contentType != "multipart/form-data" {
+		return errors.New("request Content-Type isn't multipart/form-data")
+	}
+	r.multipartOptions = opts
+	r.parseFormErr = r.parseForm()
+	return r.parseFormErr
+}
+
 func (r *Request) parseForm() error{
-	if r.Method != "POST" && r.Method != "PUT" {
+	//只有POST、PUT、PATCH的body按表单解析，GET等方法即使携带了body也不视为表单
+	switch r.Method {
+	case "POST","PUT","PATCH":
+	default:
 		return errors.New("missing form body")
 	}
 
@@ -345,44 +579,156 @@ func (r *Request) parseForm() error{
 	}
 }
 
+//给body设置新的大小上限，n小于等于0表示不限制。
+//body已经被Server.MaxRequestBodySize限制时直接修改其上限，否则重新封装一层。
+//超限时直接通知r.resp，ServeMux拿到的w可能已经被中间件包装过
+func (r *Request) setMaxBodySize(n int64) {
+	if mbr,ok := r.Body.(*maxBytesReader);ok {
+		mbr.limit = n
+		return
+	}
+	if n > 0 {
+		r.Body = newMaxBytesReader(r.resp,r.Body,n)
+	}
+}
+
 func (r *Request) parsePostForm() error {
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return err
 	}
-	r.postForm = parseQuery(string(data))
+	//同名字段(如tag=a&tag=b)的值按出现顺序全部保留，供Bind绑定到切片
+	values := make(url.Values)
+	for _,part := range strings.Split(string(data),"&") {
+		index := strings.IndexByte(part,'=')
+		if index == -1 {
+			continue
+		}
+		//urlencoded表单中的字段名和值都经过百分号编码，解码后才是客户端字符集下的原始字节
+		k,err := url.QueryUnescape(strings.TrimSpace(part[:index]))
+		if err == nil {
+			var v string
+			if v,err = url.QueryUnescape(strings.TrimSpace(part[index+1:]));err == nil {
+				values[k] = append(values[k],v)
+			}
+		}
+		if err != nil {
+			return r.rejectForm(badRequestError("invalid form encoding"))
+		}
+	}
+	if err = r.decodeFormCharset(values);err != nil {
+		return err
+	}
+	r.postFormValues = values
+	//PostForm与multipart表单一致，同名字段取第一个值
+	r.postForm = make(map[string]string,len(values))
+	for k,vs := range values {
+		r.postForm[k] = vs[0]
+	}
 	return nil
 }
 
+//表单的字符集取自Content-Type的charset参数，其次是HTML表单中名为_charset_的隐藏字段(浏览器会将其填为提交时使用的字符集)，
+//都没有时默认为UTF-8。将values中的字段名和值就地转换为UTF-8
+func (r *Request) decodeFormCharset(values map[string][]string) error {
+	charset := r.Charset()
+	if charset == "" && len(values["_charset_"]) > 0 {
+		charset = values["_charset_"][0]
+	}
+	if charset == "" {
+		charset = "utf-8"
+	}
+	decoder,ok := lookupCharset(charset)
+	if !ok {
+		return r.rejectForm(&CharsetError{Charset: charset})
+	}
+
+	decoded := make(map[string][]string,len(values))
+	for k,vs := range values {
+		key,ok := decoder([]byte(k))
+		if !ok {
+			return r.rejectForm(&CharsetError{Charset: charset,Field: k})
+		}
+		for _,v := range vs {
+			value,ok := decoder([]byte(v))
+			if !ok {
+				return r.rejectForm(&CharsetError{Charset: charset,Field: key})
+			}
+			decoded[key] = append(decoded[key],value)
+		}
+	}
+	for k := range values {
+		delete(values,k)
+	}
+	for k,vs := range decoded {
+		values[k] = vs
+	}
+	return nil
+}
+
+//表单无法解码属于客户端的错误，handler还未设置状态码时回复400
+func (r *Request) rejectForm(err error) error {
+	if r.resp != nil {
+		r.resp.WriteHeader(StatusBadRequest)
+	}
+	return err
+}
+
 func (r *Request) parseMultipartForm() error {
 	mr,err := r.MultipartReader()
 	if err != nil{
 		return  err
 	}
-	r.multipartForm,err = mr.ReadForm()
-	//让PostForm方法也可以访问multipart表单的文本数据
-	r.postForm = r.multipartForm.Value
+	r.multipartForm,err = mr.ReadForm(r.multipartOptions)
+	if err != nil {
+		return err
+	}
+	if r.resp != nil {
+		r.resp.multipartForms = append(r.resp.multipartForms,r.multipartForm)
+	}
+	if err = r.decodeFormCharset(r.multipartForm.Value);err != nil {
+		return err
+	}
+	//让PostForm方法也可以访问multipart表单的文本数据，同名字段取第一个值
+	r.postForm = make(map[string]string,len(r.multipartForm.Value))
+	for k,vs := range r.multipartForm.Value {
+		r.postForm[k] = vs[0]
+	}
 	return nil
 }
 
+//同一个字段名可以对应多个值或多个文件，如<input type="file" name="files[]" multiple>
 type MultipartForm struct {
-	Value map[string]string
-	File  map[string]*FileHeader
+	Value map[string][]string
+	File  map[string][]*FileHeader
 }
 
 //由于multipart表单可以上传文件，文件可能会很大，如果把用户上传的文件全部缓存在内存里，
 //是极为消耗资源的。所以我们采取的机制是，规定一个内存里缓存最大量
 //如果当前缓存量未超过这个值，我们将这些数据存到content这个字节切片里去。
 //如果超过这个最大值，我们则将客户端上传文件的数据暂时存储到硬盘中去，待用户需要时再读取出来。tmpFile是这个暂时文件的路径。
+//配置了FileStore时，文件保存在存储后端中，key是后端返回的标识。
 type FileHeader struct {
 	Filename string
 	Header   Header
 	Size     int
 	content  []byte
 	tmpFile  string
+	store    FileStore
+	key      string
+	//MultipartOptions.Digests指定的摘要，如Digests[DigestSHA256]
+	Digests  map[DigestAlgorithm][]byte
+}
+
+//返回文件在FileStore中的key，未使用FileStore时为空
+func (fh *FileHeader) Key() string {
+	return fh.key
 }
 
 func (fh *FileHeader) Open() (io.ReadCloser,error) {
+	if fh.store != nil {
+		return fh.store.Open(fh.key)
+	}
 	if fh.inDisk(){
 		//存储在硬盘上的情况，用户在读完这个文件之后有义务将这个文件关闭，所以我们的返回值是一个ReadCloser而不是单纯一个Reader。
 		return os.Open(fh.tmpFile)
@@ -0,0 +1,180 @@
+package httpd
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+//serveRaw在回环地址上启动一个只服务一条连接的conn，把raw原样写入后读取全部响应。
+//写完raw后关闭写端，服务端读到EOF即会结束连接
+func serveRaw(t *testing.T, h Handler, raw string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		newConn(c, &Server{Handler: h}).Serve()
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = io.WriteString(c, raw); err != nil {
+		t.Fatal(err)
+	}
+	c.(*net.TCPConn).CloseWrite()
+	resp, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(resp)
+}
+
+//echoHandler把请求路径和body写回，handler被调用过的路径记录在paths中
+func echoHandler(paths *[]string) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		*paths = append(*paths, r.Url.Path)
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(StatusBadRequest)
+			return
+		}
+		w.Write([]byte(r.Url.Path + ":" + string(b)))
+	})
+}
+
+func TestRequestFramingRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"TE and CL", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"},
+		{"differing CL", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3\r\nContent-Length: 4\r\n\r\nabcd"},
+		{"differing CL in list", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 3, 4\r\n\r\nabcd"},
+		{"plus sign CL", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +3\r\n\r\nabc"},
+		{"negative CL", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: -3\r\n\r\nabc"},
+		{"empty CL", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length:\r\n\r\nGET /smuggled HTTP/1.1\r\nHost: a\r\n\r\n"},
+		{"empty TE", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding:\r\nContent-Length: 0\r\n\r\n"},
+		{"unknown coding", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: xchunked\r\n\r\n0\r\n\r\n"},
+		{"chunked not last", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, gzip\r\n\r\n0\r\n\r\n"},
+		{"chunked not last across fields", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: gzip\r\n\r\n0\r\n\r\n"},
+		{"TE in HTTP/1.0", "POST / HTTP/1.0\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"},
+		{"obs-fold", "POST / HTTP/1.1\r\nHost: a\r\nX-A: b\r\n folded\r\nContent-Length: 0\r\n\r\n"},
+		{"space before colon", "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding : chunked\r\nContent-Length: 3\r\n\r\nabc"},
+		{"bare LF in header", "POST / HTTP/1.1\r\nHost: a\nContent-Length: 0\r\n\r\n"},
+		{"bare LF request line", "GET / HTTP/1.1\nHost: a\r\n\r\n"},
+		{"bare CR in header", "GET / HTTP/1.1\r\nHost: a\rX-A: b\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths []string
+			resp := serveRaw(t, echoHandler(&paths), tt.raw)
+			if !strings.HasPrefix(resp, "HTTP/1.1 400 ") {
+				t.Errorf("response = %q, want 400", resp)
+			}
+			if len(paths) != 0 {
+				t.Errorf("handler called for %v", paths)
+			}
+		})
+	}
+}
+
+func TestRequestFramingAccepted(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"repeated equal CL", "POST /p HTTP/1.1\r\nHost: a\r\nContent-Length: 3, 3\r\nContent-Length: 3\r\n\r\nabc"},
+		{"chunked with extension and trailer", "POST /p HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: Chunked\r\n\r\n1;x=y\r\na\r\n2\r\nbc\r\n0\r\nT: v\r\n\r\n"},
+		{"empty list elements", "POST /p HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: , chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths []string
+			resp := serveRaw(t, echoHandler(&paths), tt.raw)
+			if !strings.HasPrefix(resp, "HTTP/1.1 200 ") || !strings.HasSuffix(resp, "/p:abc") {
+				t.Errorf("response = %q", resp)
+			}
+		})
+	}
+}
+
+func TestRequestTruncatedChunk(t *testing.T) {
+	var bodyErr error
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		_, bodyErr = ioutil.ReadAll(r.Body)
+	})
+	serveRaw(t, h, "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nab")
+	if bodyErr != io.ErrUnexpectedEOF {
+		t.Errorf("body error = %v, want %v", bodyErr, io.ErrUnexpectedEOF)
+	}
+}
+
+//空值的字段要保留下来，否则"Content-Length:"会被当作没有body
+func TestReadHeaderKeepsEmptyValue(t *testing.T) {
+	h, err := readHeaderLimit(newBufReader("X-Empty:\r\nX-A:  b \r\n\r\n"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := h["X-Empty"]; !ok || len(v) != 1 || v[0] != "" {
+		t.Errorf("X-Empty = %q, %v", v, ok)
+	}
+	if got := h.Get("X-A"); got != "b" {
+		t.Errorf("X-A = %q, want %q", got, "b")
+	}
+}
+
+//wrapWriter模拟用户中间件对ResponseWriter的包装，没有实现Unwrap
+type wrapWriter struct {
+	ResponseWriter
+}
+
+//unwrapWriter是实现了Unwrap的包装
+type unwrapWriter struct {
+	ResponseWriter
+}
+
+func (w unwrapWriter) Unwrap() ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestMaxBodySizeWrappedWriter(t *testing.T) {
+	readAll := func(w ResponseWriter, r *Request) {
+		if _, err := ioutil.ReadAll(r.Body); err == nil {
+			w.Write([]byte("read"))
+		}
+	}
+	mux := NewServerMux()
+	mux.HandleFunc("/route", readAll, MaxBodySize(2))
+	mux.HandleFunc("/unwrap", func(w ResponseWriter, r *Request) {
+		r.Body = MaxBytesReader(w, r.Body, 2)
+		readAll(w, r)
+	})
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Url.Path == "/unwrap" {
+			mux.ServeHTTP(unwrapWriter{w}, r)
+			return
+		}
+		mux.ServeHTTP(wrapWriter{w}, r)
+	})
+
+	for _, path := range []string{"/route", "/unwrap"} {
+		resp := serveRaw(t, h, "POST "+path+" HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nabcde")
+		if !strings.HasPrefix(resp, "HTTP/1.1 413 ") || !strings.Contains(resp, "Connection: close") {
+			t.Errorf("%s: response = %q, want 413 with Connection: close", path, resp)
+		}
+	}
+}
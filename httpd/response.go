@@ -2,9 +2,27 @@ package httpd
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
 )
 
+//状态码为1xx、204、304时响应不能有body，此时调用Write返回该错误
+var ErrBodyNotAllowed = errors.New("httpd: request method or response status code does not allow body")
+
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == StatusNoContent, status == StatusNotModified:
+		return false
+	}
+	return true
+}
+
 type response struct {
 	//http链接
 	c *conn
@@ -35,18 +53,131 @@ type response struct {
 
 	//是否使用chunk编码的方式，一旦检测到应该使用chunk编码，则会被chunkWriter设置成true
 	chunking bool
+
+	//本次请求中解析出的multipart表单，finishRequest中删除它们的暂存文件
+	multipartForms []*MultipartForm
+
+	//handler已经写入的body字节数
+	written int64
 }
 
 //写入流的顺序：response => (*response).bufw => chunkWriter
 // => (*chunkWriter).(*response).(*conn).bufw => net.Conn
 func (w *response) Write(p []byte) (int,error) {
+	if !bodyAllowedForStatus(w.statusCode) {
+		return 0,ErrBodyNotAllowed
+	}
 	n,err := w.bufw.Write(p)
+	w.written += int64(n)
+	if err != nil {
+		w.closeAfterReply = true
+	}
+	return n,err
+}
+
+//流式响应(如server-sent events)需要在handler结束前把数据发送给客户端，handler可以通过类型断言使用：
+//	if f,ok := w.(httpd.Flusher);ok {
+//		f.Flush()
+//	}
+type Flusher interface {
+	Flush()
+}
+
+//Flush将已缓存的数据立即发送。尚未写入任何数据时只发送首部，此时长度未知，响应使用chunk编码；
+//304等没有body的响应和HEAD请求的响应不使用chunk编码，见finalizeHeader
+func (w *response) Flush() {
+	if !w.cw.wrote && w.bufw.Buffered() == 0 {
+		w.cw.finalizeHeader(nil)
+		if err := w.cw.writeHeader();err != nil {
+			w.closeAfterReply = true
+			return
+		}
+		w.cw.wrote = true
+	}
+	if err := w.bufw.Flush();err != nil {
+		w.closeAfterReply = true
+		return
+	}
+	if err := w.c.bufw.Flush();err != nil {
+		w.closeAfterReply = true
+	}
+}
+
+//隐藏ReadFrom方法，避免回退到普通拷贝时io.Copy再次调用ReadFrom
+type writerOnly struct {
+	io.Writer
+}
+
+/**
+handler用io.Copy(w,file)或ServeContent发送文件时会调用ReadFrom。普通的Write要经过response.bufw => chunkWriter => conn.bufw
+拷贝两次后才写入socket，而文件的长度已知时，可以在发送完首部后直接调用net.TCPConn.ReadFrom，
+由它使用sendfile(文件)或splice(socket)在内核中完成拷贝，数据不经过用户空间。需要同时满足以下条件，否则回退到普通的拷贝：
+	1. src是*os.File或包装了*os.File的*io.LimitedReader
+	2. handler设置了Content-Length和Content-Type，不使用chunk编码
+	3. 底层连接是*net.TCPConn，TLS等连接需要在用户空间加密
+ */
+func (w *response) ReadFrom(src io.Reader) (n int64,err error) {
+	f,limit := sendfileSource(src)
+	tcpConn,isTCP := w.c.rawConn.(*net.TCPConn)
+	cl,clErr := strconv.ParseInt(w.header.Get("Content-Length"),10,64)
+	if f == nil || !isTCP || clErr != nil || w.header.Get("Content-Type") == "" || w.header.Get("Transfer-Encoding") != "" ||
+		w.chunking || w.req.Method == "HEAD" || !bodyAllowedForStatus(w.statusCode) {
+		return io.Copy(writerOnly{w},src)
+	}
+
+	//先把首部和已缓存的数据发送出去
+	if err = w.bufw.Flush();err != nil {
+		w.closeAfterReply = true
+		return 0,err
+	}
+	if !w.cw.wrote {
+		w.cw.finalizeHeader(nil)
+		if err = w.cw.writeHeader();err != nil {
+			w.closeAfterReply = true
+			return 0,err
+		}
+		w.cw.wrote = true
+	}
+	if err = w.c.bufw.Flush();err != nil {
+		w.closeAfterReply = true
+		return 0,err
+	}
+
+	//最多发送Content-Length中剩余的字节数，多余的数据会被客户端当作下一个响应
+	remaining := cl - w.written
+	if limit < 0 || limit > remaining {
+		limit = remaining
+	}
+	if limit > 0 {
+		testHookSendfile()
+		n,err = tcpConn.ReadFrom(&io.LimitedReader{R: f,N: limit})
+	}
+	w.written += n
+	if lr,ok := src.(*io.LimitedReader);ok {
+		lr.N -= n
+	}
 	if err != nil {
 		w.closeAfterReply = true
 	}
 	return n,err
 }
 
+//测试用，文件交给tcpConn.ReadFrom之前调用
+var testHookSendfile = func() {}
+
+//返回可以交给sendfile的文件及最多读取的字节数，-1表示读到文件末尾
+func sendfileSource(src io.Reader) (*os.File,int64) {
+	switch v := src.(type) {
+	case *os.File:
+		return v,-1
+	case *io.LimitedReader:
+		if f,ok := v.R.(*os.File);ok {
+			return f,v.N
+		}
+	}
+	return nil,0
+}
+
 func (w *response) Header() Header {
 	return w.header
 }
@@ -59,6 +190,41 @@ func (w *response) WriteHeader(statusCode int) {
 	w.statusCode = statusCode
 	w.wroteHeader = true
 }
+
+//中间件包装ResponseWriter时应实现该接口返回被包装的对象，
+//框架据此找到内部的response，如MaxBytesReader超限时回复413
+type Unwrapper interface {
+	Unwrap() ResponseWriter
+}
+
+//沿着Unwrap链找到框架内部的response，找不到时返回nil
+func unwrapResponse(w ResponseWriter) *response {
+	for w != nil {
+		switch v := w.(type) {
+		case *response:
+			return v
+		case Unwrapper:
+			w = v.Unwrap()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+//DecompressBody解码body时发现数据损坏时调用。handler还未设置状态码时回复400，
+//连接上剩余的body已经无法解析，响应结束后关闭连接
+func (w *response) requestBodyInvalid() {
+	w.closeAfterReply = true
+	w.WriteHeader(StatusBadRequest)
+}
+
+//body超过MaxBytesReader的上限时调用。handler还未设置状态码时回复413，
+//且无论如何都要关闭连接，因为连接上还残留着未读取的body
+func (w *response) requestTooLarge() {
+	w.closeAfterReply = true
+	w.WriteHeader(StatusRequestEntityTooLarge)
+}
 //我们框架的解决方案是规定最多缓存4KB数据，如果用户在handler中写入的量小于这个值，我们使用Content-Length，否则使用chunk编码的方式。
 //chunk编码的解析效率会比Content-Length方式低上很多，同时也有控制信息等数据开销，我们要兼顾性能进行考虑,因此不直接全部用chunk方式
 func setupResponse(c *conn,req *Request)*response {
@@ -69,6 +235,7 @@ func setupResponse(c *conn,req *Request)*response {
 		req:req,
 	}
 
+	req.resp = resp
 	cw := &chunkWriter{resp: resp}
 	resp.cw = cw
 	//此处将cw作为bufw的底层writer传入，调用resp.bufw.Flush时，会将数据写入到cw中
@@ -78,8 +245,8 @@ func setupResponse(c *conn,req *Request)*response {
 		protoMajor int
 	)
 
-	fmt.Sscanf(req.Proto,"HTTP/%d.%d",protoMajor,protoMinor)
-	if protoMajor < 1 || protoMinor == 1 && protoMajor == 0 || req.Header.Get("Connection") == "close" {
+	fmt.Sscanf(req.Proto,"HTTP/%d.%d",&protoMajor,&protoMinor)
+	if protoMajor < 1 || protoMajor == 1 && protoMinor == 0 || req.Header.Get("Connection") == "close" {
 		resp.closeAfterReply = true
 	}
 	return resp
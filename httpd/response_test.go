@@ -0,0 +1,302 @@
+package httpd
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//创建一个size字节的随机内容文件
+func writeTempFile(tb testing.TB, name string, size int) (string, []byte) {
+	tb.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	file := filepath.Join(tb.TempDir(), name)
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		tb.Fatal(err)
+	}
+	return file, data
+}
+
+//记录tcpConn.ReadFrom被使用的次数
+func countSendfile(tb testing.TB) *int {
+	n := new(int)
+	old := testHookSendfile
+	testHookSendfile = func() { *n++ }
+	tb.Cleanup(func() { testHookSendfile = old })
+	return n
+}
+
+//在内存中记录handler的响应，用于不需要经过连接的测试
+type recorder struct {
+	header Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: make(Header)}
+}
+
+func (w *recorder) Header() Header {
+	return w.header
+}
+
+func (w *recorder) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *recorder) Write(p []byte) (int, error) {
+	w.WriteHeader(StatusOK)
+	return w.body.Write(p)
+}
+
+func newTestRequest(method string, header ...string) *Request {
+	r := &Request{Method: method, Header: make(Header)}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Add(header[i], header[i+1])
+	}
+	return r
+}
+
+func parseResponse(t *testing.T, raw, method string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(raw)), &http.Request{Method: method})
+	if err != nil {
+		t.Fatalf("read response %q: %v", raw, err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestReadFromSendfile(t *testing.T) {
+	file, data := writeTempFile(t, "a.bin", 100<<10)
+	sendfiles := countSendfile(t)
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		ServeFile(w, r, file)
+	})
+
+	raw := serveRaw(t, h, "GET /a.bin HTTP/1.1\r\nHost: a\r\n\r\n")
+	resp, body := parseResponse(t, raw, "GET")
+	if resp.StatusCode != 200 || !bytes.Equal(body, data) {
+		t.Fatalf("status %d, %d bytes", resp.StatusCode, len(body))
+	}
+	if resp.ContentLength != int64(len(data)) || len(resp.TransferEncoding) != 0 {
+		t.Errorf("Content-Length %d, Transfer-Encoding %v", resp.ContentLength, resp.TransferEncoding)
+	}
+	if *sendfiles != 1 {
+		t.Errorf("sendfile used %d times, want 1", *sendfiles)
+	}
+
+	//Range请求同样走sendfile，只发送区间内的数据
+	*sendfiles = 0
+	raw = serveRaw(t, h, "GET /a.bin HTTP/1.1\r\nHost: a\r\nRange: bytes=10-19\r\n\r\n")
+	resp, body = parseResponse(t, raw, "GET")
+	if resp.StatusCode != StatusPartialContent || !bytes.Equal(body, data[10:20]) {
+		t.Errorf("range: status %d, body %x", resp.StatusCode, body)
+	}
+	if *sendfiles != 1 {
+		t.Errorf("range: sendfile used %d times, want 1", *sendfiles)
+	}
+}
+
+//不满足sendfile条件时回退到普通拷贝，内容不受影响
+func TestReadFromFallback(t *testing.T) {
+	file, data := writeTempFile(t, "a.bin", 100<<10)
+	sendfiles := countSendfile(t)
+	readFrom := func(setHeaders func(h Header)) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			f, err := os.Open(file)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer f.Close()
+			setHeaders(w.Header())
+			if _, err = w.(io.ReaderFrom).ReadFrom(f); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	length := strconv.Itoa(len(data))
+
+	tests := []struct {
+		name    string
+		method  string
+		h       Handler
+		chunked bool
+	}{
+		{"HEAD", "HEAD", readFrom(func(h Header) {
+			h.Set("Content-Type", "application/octet-stream")
+			h.Set("Content-Length", length)
+		}), false},
+		{"chunked", "GET", readFrom(func(h Header) {
+			h.Set("Content-Type", "application/octet-stream")
+		}), true},
+		{"no Content-Type", "GET", readFrom(func(h Header) {
+			h.Set("Content-Length", length)
+		}), false},
+		{"not a file", "GET", HandlerFunc(func(w ResponseWriter, r *Request) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Length", length)
+			w.(io.ReaderFrom).ReadFrom(bytes.NewReader(data))
+		}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*sendfiles = 0
+			raw := serveRaw(t, tt.h, tt.method+" / HTTP/1.1\r\nHost: a\r\n\r\n")
+			resp, body := parseResponse(t, raw, tt.method)
+			if *sendfiles != 0 {
+				t.Errorf("sendfile used %d times, want 0", *sendfiles)
+			}
+			if resp.StatusCode != 200 {
+				t.Fatalf("status %d", resp.StatusCode)
+			}
+			if chunked := len(resp.TransferEncoding) > 0; chunked != tt.chunked {
+				t.Errorf("chunked = %v, want %v", chunked, tt.chunked)
+			}
+			want := data
+			if tt.method == "HEAD" {
+				want = nil
+			}
+			if !bytes.Equal(body, want) {
+				t.Errorf("got %d bytes, want %d", len(body), len(want))
+			}
+		})
+	}
+}
+
+//在回环地址上的同一条连接中反复请求同一个文件，size为文件大小
+func benchmarkServeFile(b *testing.B, h func(file string) Handler, size int) {
+	file, _ := writeTempFile(b, "bench.bin", size)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		newConn(c, &Server{Handler: h(file)}).Serve()
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+
+	req := []byte("GET /bench.bin HTTP/1.1\r\nHost: a\r\n\r\n")
+	br := bufio.NewReader(c)
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = c.Write(req); err != nil {
+			b.Fatal(err)
+		}
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		n, err := io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil || n != int64(size) {
+			b.Fatalf("read %d bytes: %v", n, err)
+		}
+	}
+}
+
+func BenchmarkServeFileSendfile(b *testing.B) {
+	benchmarkServeFile(b, func(file string) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			ServeFile(w, r, file)
+		})
+	}, 4<<20)
+}
+
+//内容相同，但把*os.File隐藏起来，只能在用户空间拷贝
+func BenchmarkServeFileCopy(b *testing.B) {
+	benchmarkServeFile(b, func(file string) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			f, err := os.Open(file)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			defer f.Close()
+			fi, _ := f.Stat()
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+			io.Copy(w, struct{ io.Reader }{f})
+		})
+	}, 4<<20)
+}
+
+//没有body的响应在Flush之后不能带上chunk编码，否则结尾的0\r\n\r\n会被当作下一个响应的开头
+func TestFlushNoBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		status int
+		body   string
+	}{
+		{"304", "GET", StatusNotModified, ""},
+		{"204", "GET", StatusNoContent, ""},
+		{"HEAD", "HEAD", StatusOK, "ignored body"},
+		{"HEAD large body", "HEAD", StatusOK, strings.Repeat("x", 10<<10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := HandlerFunc(func(w ResponseWriter, r *Request) {
+				if r.Url.Path == "/next" {
+					w.Write([]byte("next"))
+					return
+				}
+				w.WriteHeader(tt.status)
+				w.(Flusher).Flush()
+				if tt.body != "" {
+					w.Write([]byte(tt.body))
+					w.(Flusher).Flush()
+				}
+			})
+			//同一条连接上紧跟一个请求，检查第一个响应之后没有多余的数据
+			raw := serveRaw(t, h, tt.method+" / HTTP/1.1\r\nHost: a\r\n\r\nGET /next HTTP/1.1\r\nHost: a\r\n\r\n")
+			br := bufio.NewReader(strings.NewReader(raw))
+			resp, err := http.ReadResponse(br, &http.Request{Method: tt.method})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if len(resp.TransferEncoding) != 0 || resp.Header.Get("Transfer-Encoding") != "" {
+				t.Errorf("Transfer-Encoding %v on a response without body", resp.TransferEncoding)
+			}
+			next, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("second response: %v (raw %q)", err, raw)
+			}
+			body, _ := ioutil.ReadAll(next.Body)
+			if string(body) != "next" {
+				t.Errorf("second response body %q", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+//securecookie对cookie的值进行HMAC签名，并可选地使用AES-GCM加密，防止客户端篡改或读取其中的数据。
+//
+//编码后的值为base64url(时间戳|数据|签名)，签名覆盖cookie名、时间戳和数据，
+//因此一个cookie的值不能被挪用到另一个同名之外的cookie上，也不能修改其中的时间戳来延长有效期。
+package securecookie
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/dbldqt/httpImp/httpd"
+)
+
+var (
+	ErrHashKeyTooShort = errors.New("securecookie: hash key must be at least 32 bytes")
+	ErrValueTooLong    = errors.New("securecookie: encoded value too long")
+	ErrMalformed       = errors.New("securecookie: malformed value")
+	ErrInvalidMAC      = errors.New("securecookie: invalid signature")
+	ErrDecryptFailed   = errors.New("securecookie: decryption failed")
+	ErrExpired         = errors.New("securecookie: value expired")
+	ErrNoCookie        = errors.New("securecookie: named cookie not present")
+)
+
+const (
+	timestampLen = 8
+	macLen       = sha256.Size
+	//浏览器对单个cookie的限制一般为4096字节(含名称和属性)
+	defaultMaxLength = 4096
+)
+
+//Key是一组签名及加密密钥。Hash用于HMAC-SHA256，至少32字节；
+//Block为AES密钥，长度为16、24或32字节，为nil时只签名不加密
+type Key struct {
+	Hash  []byte
+	Block []byte
+}
+
+type keyPair struct {
+	hash []byte
+	aead cipher.AEAD
+}
+
+/**
+Codec负责cookie值的编码和解码：
+	sc, err := securecookie.New(securecookie.Key{Hash: newHash, Block: newBlock},
+		securecookie.Key{Hash: oldHash, Block: oldBlock})
+	sc.MaxAge = 24 * time.Hour
+第一组密钥用于编码，所有密钥都用于解码，轮换密钥时把新密钥放在最前面，
+旧密钥保留到用它签发的cookie全部过期为止
+*/
+type Codec struct {
+	//值的最大存活时间，根据编码时嵌入的时间戳判断，0表示不限制。
+	//它与Cookie.MaxAge相互独立，即使客户端无视Max-Age继续发送cookie，过期的值也不会被接受
+	MaxAge time.Duration
+	//编码后值的最大长度，0表示4096
+	MaxLength int
+
+	keys []keyPair
+}
+
+//New根据一组或多组密钥创建Codec，至少需要一组密钥
+func New(keys ...Key) (*Codec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("securecookie: no keys provided")
+	}
+	c := &Codec{}
+	for _, k := range keys {
+		if len(k.Hash) < 32 {
+			return nil, ErrHashKeyTooShort
+		}
+		kp := keyPair{hash: k.Hash}
+		if k.Block != nil {
+			block, err := aes.NewCipher(k.Block)
+			if err != nil {
+				return nil, err
+			}
+			if kp.aead, err = cipher.NewGCM(block); err != nil {
+				return nil, err
+			}
+		}
+		c.keys = append(c.keys, kp)
+	}
+	return c, nil
+}
+
+//GenerateKey返回length字节的随机密钥，用于生成Key.Hash(建议64字节)和Key.Block(32字节)
+func GenerateKey(length int) []byte {
+	k := make([]byte, length)
+	if _, err := io.ReadFull(rand.Reader, k); err != nil {
+		panic("securecookie: crypto/rand failed: " + err.Error())
+	}
+	return k
+}
+
+//EncodeBytes使用第一组密钥对data签名(及加密)，返回可以直接作为cookie值的字符串
+func (c *Codec) EncodeBytes(name string, data []byte) (string, error) {
+	return c.encodeAt(name, data, time.Now())
+}
+
+func (c *Codec) encodeAt(name string, data []byte, now time.Time) (string, error) {
+	kp := c.keys[0]
+	buf := make([]byte, timestampLen, timestampLen+len(data)+64)
+	binary.BigEndian.PutUint64(buf, uint64(now.Unix()))
+	if kp.aead != nil {
+		nonce := make([]byte, kp.aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return "", err
+		}
+		buf = append(buf, nonce...)
+		//cookie名和时间戳作为附加数据参与认证
+		buf = kp.aead.Seal(buf, nonce, data, additionalData(name, buf[:timestampLen]))
+	} else {
+		buf = append(buf, data...)
+	}
+	buf = append(buf, mac(kp.hash, name, buf)...)
+	s := base64.RawURLEncoding.EncodeToString(buf)
+	if len(s) > c.maxLength() {
+		return "", ErrValueTooLong
+	}
+	return s, nil
+}
+
+//DecodeBytes校验value的签名和时间戳，返回编码时的原始数据。依次尝试每组密钥，任意一组签名通过且能够解密即可
+func (c *Codec) DecodeBytes(name, value string) ([]byte, error) {
+	return c.decodeAt(name, value, time.Now())
+}
+
+func (c *Codec) decodeAt(name, value string, now time.Time) ([]byte, error) {
+	if len(value) > c.maxLength() {
+		return nil, ErrValueTooLong
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(buf) < timestampLen+macLen {
+		return nil, ErrMalformed
+	}
+	payload, sum := buf[:len(buf)-macLen], buf[len(buf)-macLen:]
+
+	//只轮换Block时新旧两组密钥的Hash相同，签名都能通过，但只有其中一组能够解密，
+	//因此签名通过后解密失败的要继续尝试后面的密钥
+	err = ErrInvalidMAC
+	for i := range c.keys {
+		kp := &c.keys[i]
+		if !hmac.Equal(sum, mac(kp.hash, name, payload)) {
+			continue
+		}
+		if err == ErrInvalidMAC {
+			ts := int64(binary.BigEndian.Uint64(payload))
+			if c.MaxAge > 0 && now.Unix()-ts > int64(c.MaxAge/time.Second) {
+				return nil, ErrExpired
+			}
+			//签名合法但时间戳在未来，只可能是密钥泄露或时钟严重偏差，一律拒绝
+			if ts > now.Add(time.Minute).Unix() {
+				return nil, ErrExpired
+			}
+		}
+		data := payload[timestampLen:]
+		if kp.aead == nil {
+			return data, nil
+		}
+		ns := kp.aead.NonceSize()
+		if len(data) < ns {
+			err = ErrMalformed
+			continue
+		}
+		if data, err = kp.aead.Open(nil, data[:ns], data[ns:], additionalData(name, payload[:timestampLen])); err == nil {
+			return data, nil
+		}
+		err = ErrDecryptFailed
+	}
+	return nil, err
+}
+
+//Encode将v以JSON序列化后编码，v通常是一个结构体
+func (c *Codec) Encode(name string, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return c.EncodeBytes(name, data)
+}
+
+//Decode校验并解码value，再将其中的JSON反序列化到dst中
+func (c *Codec) Decode(name, value string, dst interface{}) error {
+	data, err := c.DecodeBytes(name, value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+//SetCookie将v编码后作为cookie的值，cookie的其它属性(Path、MaxAge等)由调用方在c中设置。
+//c的Value会被覆盖
+func (c *Codec) SetCookie(w httpd.ResponseWriter, cookie *httpd.Cookie, v interface{}) error {
+	value, err := c.Encode(cookie.Name, v)
+	if err != nil {
+		return err
+	}
+	cookie.Value = value
+	return httpd.SetCookie(w, cookie)
+}
+
+//ReadCookie读取请求中名为name的cookie并解码到dst中。请求中可能存在多个同名cookie(如不同Path下设置的)，
+//返回第一个能够通过校验的，都不能通过时返回第一个cookie的错误，不存在时返回ErrNoCookie
+func (c *Codec) ReadCookie(r *httpd.Request, name string, dst interface{}) error {
+	var firstErr error
+	for _, ck := range r.Cookies() {
+		if ck.Name != name {
+			continue
+		}
+		data, err := c.DecodeBytes(name, ck.Value)
+		if err == nil {
+			return json.Unmarshal(data, dst)
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		return ErrNoCookie
+	}
+	return firstErr
+}
+
+func (c *Codec) maxLength() int {
+	if c.MaxLength > 0 {
+		return c.MaxLength
+	}
+	return defaultMaxLength
+}
+
+func mac(key []byte, name string, payload []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(additionalData(name, nil))
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+//cookie名以\x00结尾，避免名称与后面的数据拼接后产生歧义
+func additionalData(name string, ts []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(name)
+	b.WriteByte(0)
+	b.Write(ts)
+	return b.Bytes()
+}
@@ -0,0 +1,192 @@
+package securecookie
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustNew(t *testing.T, keys ...Key) *Codec {
+	t.Helper()
+	c, err := New(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := map[string]Key{
+		"sign only": {Hash: GenerateKey(64)},
+		"encrypted": {Hash: GenerateKey(64), Block: GenerateKey(32)},
+		"aes-128":   {Hash: GenerateKey(32), Block: GenerateKey(16)},
+	}
+	for name, key := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := mustNew(t, key)
+			type user struct {
+				Name string
+				ID   int
+			}
+			value, err := c.Encode("session", user{"bob", 7})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.ContainsAny(value, "=+/") {
+				t.Errorf("value %q is not base64url without padding", value)
+			}
+			raw, _ := base64.RawURLEncoding.DecodeString(value)
+			if encrypted := !bytes.Contains(raw, []byte("bob")); encrypted != (key.Block != nil) {
+				t.Errorf("encrypted = %v, want %v", encrypted, key.Block != nil)
+			}
+			var got user
+			if err = c.Decode("session", value, &got); err != nil || got != (user{"bob", 7}) {
+				t.Errorf("Decode = %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(); err == nil {
+		t.Error("New() with no keys succeeded")
+	}
+	if _, err := New(Key{Hash: make([]byte, 31)}); err != ErrHashKeyTooShort {
+		t.Errorf("short hash key: %v", err)
+	}
+	if _, err := New(Key{Hash: GenerateKey(32), Block: make([]byte, 15)}); err == nil {
+		t.Error("invalid AES key length accepted")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	hash, oldHash := GenerateKey(64), GenerateKey(64)
+	oldBlock, newBlock := GenerateKey(32), GenerateKey(32)
+	tests := []struct {
+		name     string
+		old, new Key
+	}{
+		{"both keys", Key{Hash: oldHash, Block: oldBlock}, Key{Hash: hash, Block: newBlock}},
+		{"hash only", Key{Hash: oldHash, Block: oldBlock}, Key{Hash: hash, Block: oldBlock}},
+		//Hash不变，只轮换Block：新密钥的签名也能通过，但只有旧Block能解密
+		{"block only", Key{Hash: hash, Block: oldBlock}, Key{Hash: hash, Block: newBlock}},
+		{"sign only", Key{Hash: oldHash}, Key{Hash: hash}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldValue, err := mustNew(t, tt.old).EncodeBytes("s", []byte("old"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rotated := mustNew(t, tt.new, tt.old)
+			if got, err := rotated.DecodeBytes("s", oldValue); err != nil || string(got) != "old" {
+				t.Errorf("old value: %q, %v", got, err)
+			}
+			newValue, err := rotated.EncodeBytes("s", []byte("new"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := mustNew(t, tt.new).DecodeBytes("s", newValue); err != nil || string(got) != "new" {
+				t.Errorf("new value not encoded with the first key: %q, %v", got, err)
+			}
+			//旧密钥移除之后，旧值不再被接受
+			if _, err = mustNew(t, tt.new).DecodeBytes("s", oldValue); err == nil {
+				t.Error("old value accepted without the old key")
+			}
+		})
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	c := mustNew(t, Key{Hash: GenerateKey(64), Block: GenerateKey(32)})
+	c.MaxAge = time.Hour
+	now := time.Now()
+	tests := []struct {
+		name    string
+		encoded time.Time
+		err     error
+	}{
+		{"fresh", now.Add(-time.Minute), nil},
+		{"at max age", now.Add(-time.Hour), nil},
+		{"expired", now.Add(-time.Hour - time.Second), ErrExpired},
+		{"small clock skew", now.Add(30 * time.Second), nil},
+		{"future", now.Add(time.Hour), ErrExpired},
+	}
+	for _, tt := range tests {
+		value, err := c.encodeAt("s", []byte("v"), tt.encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.decodeAt("s", value, now); err != tt.err {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	//MaxAge为0时不限制存活时间，但仍然拒绝未来的时间戳
+	c.MaxAge = 0
+	value, _ := c.encodeAt("s", []byte("v"), now.Add(-24*365*time.Hour))
+	if _, err := c.decodeAt("s", value, now); err != nil {
+		t.Errorf("MaxAge 0: %v", err)
+	}
+	value, _ = c.encodeAt("s", []byte("v"), now.Add(time.Hour))
+	if _, err := c.decodeAt("s", value, now); err != ErrExpired {
+		t.Errorf("MaxAge 0, future timestamp: %v", err)
+	}
+}
+
+func TestTampered(t *testing.T) {
+	for _, key := range []Key{{Hash: GenerateKey(64)}, {Hash: GenerateKey(64), Block: GenerateKey(32)}} {
+		c := mustNew(t, key)
+		value, err := c.EncodeBytes("s", []byte("payload"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := base64.RawURLEncoding.DecodeString(value)
+		//依次修改时间戳、数据(密文)和签名中的一个字节
+		for _, i := range []int{0, timestampLen + 1, len(raw) - macLen - 1, len(raw) - 1} {
+			b := append([]byte(nil), raw...)
+			b[i] ^= 0x80
+			if _, err = c.DecodeBytes("s", base64.RawURLEncoding.EncodeToString(b)); err != ErrInvalidMAC {
+				t.Errorf("byte %d flipped: err = %v, want ErrInvalidMAC", i, err)
+			}
+		}
+		if _, err = c.DecodeBytes("s", value[:len(value)-1]+"!"); err != ErrMalformed {
+			t.Errorf("invalid base64: %v", err)
+		}
+		if _, err = c.DecodeBytes("s", "AAAA"); err != ErrMalformed {
+			t.Errorf("short value: %v", err)
+		}
+		if _, err = c.DecodeBytes("s", strings.Repeat("A", 5000)); err != ErrValueTooLong {
+			t.Errorf("long value: %v", err)
+		}
+	}
+}
+
+//签名绑定了cookie名，值不能被复制到另一个cookie中使用
+func TestNameBinding(t *testing.T) {
+	for _, key := range []Key{{Hash: GenerateKey(64)}, {Hash: GenerateKey(64), Block: GenerateKey(32)}} {
+		c := mustNew(t, key)
+		value, err := c.EncodeBytes("role", []byte("admin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"other", "rol", "role\x00", "Role"} {
+			if _, err = c.DecodeBytes(name, value); err != ErrInvalidMAC {
+				t.Errorf("decoded as %q: err = %v", name, err)
+			}
+		}
+	}
+}
+
+func TestMaxLength(t *testing.T) {
+	c := mustNew(t, Key{Hash: GenerateKey(64)})
+	if _, err := c.EncodeBytes("s", make([]byte, 4096)); err != ErrValueTooLong {
+		t.Errorf("4096-byte payload: %v", err)
+	}
+	c.MaxLength = 100
+	if _, err := c.EncodeBytes("s", make([]byte, 60)); err != ErrValueTooLong {
+		t.Errorf("MaxLength 100: %v", err)
+	}
+}
@@ -2,11 +2,15 @@ package httpd
 
 import (
 	"net"
+	"strings"
 )
 
 type Server struct {
 	Addr string
 	Handler Handler
+	//请求body的默认最大字节数，超出时回复413并关闭连接，0表示不限制。
+	//可以在ServeMux注册路由时通过MaxBodySize为单个路由覆盖该值
+	MaxRequestBodySize int64
 }
 
 func (s *Server)ListenAndServe() error{
@@ -28,58 +32,120 @@ func (s *Server)ListenAndServe() error{
 
 type HandlerFunc func(w ResponseWriter,r *Request)
 
+//HandlerFunc也是一个Handler，方便中间件包装普通函数
+func (f HandlerFunc) ServeHTTP(w ResponseWriter,r *Request) {
+	f(w,r)
+}
+
 type Handler interface {
 	ServeHTTP(w ResponseWriter,r *Request)
 }
 
 type ServeMux struct {
-	m map[string]HandlerFunc
+	m map[string]*route
+	//含有路径参数的路由，如/users/{id}，按注册顺序匹配
+	paramRoutes []*route
+}
+
+type route struct {
+	//按/切分后的pattern，仅含有路径参数的路由使用
+	segments []string
+	handler HandlerFunc
+	//该路由的body大小上限，为nil时沿用Server.MaxRequestBodySize
+	maxBodySize *int64
+}
+
+//注册路由时的可选配置
+type RouteOption func(rt *route)
+
+//为单个路由设置请求body的最大字节数，覆盖Server.MaxRequestBodySize，n小于等于0表示不限制
+func MaxBodySize(n int64) RouteOption {
+	return func(rt *route) {
+		rt.maxBodySize = &n
+	}
 }
 
 func NewServerMux() *ServeMux {
 	return &ServeMux{
-		m:make(map[string]HandlerFunc),
+		m:make(map[string]*route),
 	}
 }
 
-func (sm *ServeMux)HandleFunc(pattern string,cb HandlerFunc) {
+func (sm *ServeMux)HandleFunc(pattern string,cb HandlerFunc,opts ...RouteOption) {
 	if sm.m == nil {
-		sm.m = make(map[string]HandlerFunc)
+		sm.m = make(map[string]*route)
+	}
+	rt := &route{handler: cb}
+	for _,opt := range opts {
+		opt(rt)
+	}
+	if strings.Contains(pattern,"{") {
+		rt.segments = strings.Split(strings.TrimSuffix(pattern,"/"),"/")
+		sm.paramRoutes = append(sm.paramRoutes,rt)
+		return
 	}
-	sm.m[pattern] = cb
+	sm.m[pattern] = rt
 }
 
-func (sm *ServeMux) Handle(pattern string,handler Handler) {
-	if sm.m == nil {
-		sm.m = make(map[string]HandlerFunc)
+//匹配形如/users/{id}/posts/{pid}的路由，{name}匹配一个非空的路径段，匹配成功时返回路径参数
+func (rt *route) match(path string) (map[string]string,bool) {
+	segments := strings.Split(strings.TrimSuffix(path,"/"),"/")
+	if len(segments) != len(rt.segments) {
+		return nil,false
+	}
+	params := make(map[string]string)
+	for i,seg := range rt.segments {
+		if len(seg) > 2 && seg[0] == '{' && seg[len(seg)-1] == '}' {
+			if segments[i] == "" {
+				return nil,false
+			}
+			params[seg[1:len(seg)-1]] = segments[i]
+			continue
+		}
+		if seg != segments[i] {
+			return nil,false
+		}
 	}
-	sm.m[pattern] = handler.ServeHTTP
+	return params,true
+}
+
+func (sm *ServeMux) Handle(pattern string,handler Handler,opts ...RouteOption) {
+	sm.HandleFunc(pattern,handler.ServeHTTP,opts...)
 }
 
 func (sm *ServeMux) ServeHTTP(w ResponseWriter, r *Request) {
-	handler, ok := sm.m[r.Url.Path]
+	rt, ok := sm.m[r.Url.Path]
 	if !ok {
 		if len(r.Url.Path) > 1 && r.Url.Path[len(r.Url.Path)-1] == '/' {
-			handler, ok = sm.m[r.Url.Path[:len(r.Url.Path)-1]]
+			rt, ok = sm.m[r.Url.Path[:len(r.Url.Path)-1]]
+		}
+		for i := 0;!ok && i < len(sm.paramRoutes);i++ {
+			if params,matched := sm.paramRoutes[i].match(r.Url.Path);matched {
+				rt,ok = sm.paramRoutes[i],true
+				r.pathParams = params
+			}
 		}
 		if !ok {
 			w.WriteHeader(StatusNotFound)
 			return
 		}
 	}
-	handler(w, r)
+	if rt.maxBodySize != nil {
+		r.setMaxBodySize(*rt.maxBodySize)
+	}
+	rt.handler(w, r)
 }
 
 var defaultServeMux ServeMux
 
 var DefaultServeMux = &defaultServeMux
 
-func HandleFunc(pattern string, cb HandlerFunc) {
-	DefaultServeMux.HandleFunc(pattern, cb)
+func HandleFunc(pattern string, cb HandlerFunc, opts ...RouteOption) {
+	DefaultServeMux.HandleFunc(pattern, cb, opts...)
 }
 
-func Handle(pattern string, handler Handler) {
-	DefaultServeMux.Handle(pattern, handler)
+func Handle(pattern string, handler Handler, opts ...RouteOption) {
+	DefaultServeMux.Handle(pattern, handler, opts...)
 }
 
 func ListenAndServe(addr string, handler Handler) error {
@@ -0,0 +1,359 @@
+/**
+sessions在cookie之上实现登录会话：
+	mgr := sessions.NewManager(sessions.NewMemoryStore())
+	mux.HandleFunc("/login", func(w httpd.ResponseWriter, r *httpd.Request) {
+		s := sessions.Get(r)
+		s.RegenerateID() //权限变化时更换会话ID，防止会话固定攻击
+		s.Set("user", "bob")
+		s.AddFlash("welcome back")
+	})
+	httpd.ListenAndServe(":8080", mgr.Middleware(mux))
+会话数据保存在Store中，cookie中只保存Store返回的令牌。会话在第一次写响应时自动保存，
+新建且未修改过的会话不会保存，也不会下发cookie。
+*/
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dbldqt/httpImp/httpd"
+)
+
+//Store中不存在令牌对应的会话，或者会话已过期
+var ErrNotFound = errors.New("sessions: session not found")
+
+//Record是Store中保存的会话数据。文件和cookie存储使用JSON序列化，取回后数字为float64、结构体为map[string]interface{}
+type Record struct {
+	ID         string                 `json:"id"`
+	Values     map[string]interface{} `json:"values,omitempty"`
+	Flashes    []interface{}          `json:"flashes,omitempty"`
+	Created    time.Time              `json:"created"`
+	LastAccess time.Time              `json:"last_access"`
+}
+
+//Store负责会话数据的持久化
+type Store interface {
+	//Load根据cookie中的令牌取出会话，不存在或已过期时返回ErrNotFound
+	Load(token string) (*Record, error)
+	//Save保存会话，ttl后失效，ttl<=0表示不过期。返回写入cookie的令牌。服务端存储的令牌就是会话ID，cookie存储的令牌是编码后的会话数据
+	Save(rec *Record, ttl time.Duration) (token string, err error)
+	Delete(token string) error
+}
+
+type contextKey struct{}
+
+//Manager从请求的cookie中加载会话，并在响应时保存
+type Manager struct {
+	Store      Store
+	CookieName string
+	//超过IdleTimeout未访问的会话失效，每次请求都会顺延；AbsoluteTimeout是会话从创建起的最长存活时间，
+	//与是否活跃无关。两者为0时表示不限制
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+	//下发cookie时使用的Path、Domain、Secure、HttpOnly、SameSite等属性，Name、Value和MaxAge由Manager设置
+	Cookie httpd.Cookie
+}
+
+//NewManager使用默认配置创建Manager：cookie名为session，空闲30分钟或创建24小时后失效，cookie为HttpOnly、SameSite=Lax
+func NewManager(store Store) *Manager {
+	return &Manager{
+		Store:           store,
+		CookieName:      "session",
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 24 * time.Hour,
+		Cookie: httpd.Cookie{
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: httpd.SameSiteLaxMode,
+		},
+	}
+}
+
+//Middleware为next中的每个请求加载会话，handler中通过Get取得
+func (m *Manager) Middleware(next httpd.Handler) httpd.Handler {
+	return httpd.HandlerFunc(func(w httpd.ResponseWriter, r *httpd.Request) {
+		sw := &sessionWriter{ResponseWriter: w}
+		s := m.load(r, sw)
+		sw.s = s
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), contextKey{}, s)))
+		//handler没有写任何数据时，在这里保存
+		if err := s.Save(); err != nil {
+			log.Printf("sessions: save session: %v", err)
+		}
+	})
+}
+
+//Get返回Middleware为请求加载的会话，请求未经过Middleware时返回nil
+func Get(r *httpd.Request) *Session {
+	s, _ := r.Context().Value(contextKey{}).(*Session)
+	return s
+}
+
+func (m *Manager) load(r *httpd.Request, w httpd.ResponseWriter) *Session {
+	now := time.Now()
+	s := &Session{mgr: m, w: w}
+	//同名cookie可能有多个，取第一个有效的
+	for _, c := range r.Cookies() {
+		if c.Name != m.CookieName {
+			continue
+		}
+		rec, err := m.Store.Load(c.Value)
+		if err != nil {
+			if err != ErrNotFound {
+				log.Printf("sessions: load session: %v", err)
+			}
+			continue
+		}
+		if m.expired(rec, now) {
+			m.Store.Delete(c.Value)
+			continue
+		}
+		if rec.Values == nil {
+			rec.Values = make(map[string]interface{})
+		}
+		s.rec, s.token = rec, c.Value
+		return s
+	}
+	s.rec = &Record{ID: newID(), Values: make(map[string]interface{}), Created: now, LastAccess: now}
+	s.isNew = true
+	return s
+}
+
+func (m *Manager) expired(rec *Record, now time.Time) bool {
+	if m.IdleTimeout > 0 && now.Sub(rec.LastAccess) > m.IdleTimeout {
+		return true
+	}
+	return m.AbsoluteTimeout > 0 && now.Sub(rec.Created) > m.AbsoluteTimeout
+}
+
+//会话剩余的有效时间，取空闲超时和绝对超时中较早的一个，两者都不限制时返回0
+func (m *Manager) ttl(rec *Record, now time.Time) time.Duration {
+	ttl := m.IdleTimeout
+	if m.AbsoluteTimeout > 0 {
+		remain := rec.Created.Add(m.AbsoluteTimeout).Sub(now)
+		//绝对超时恰好已到，不能返回0，否则会被当作不过期
+		if remain <= 0 {
+			remain = time.Nanosecond
+		}
+		if ttl <= 0 || remain < ttl {
+			ttl = remain
+		}
+	}
+	return ttl
+}
+
+func (m *Manager) setCookie(w httpd.ResponseWriter, value string, ttl time.Duration) error {
+	c := m.Cookie
+	c.Name, c.Value, c.MaxAge = m.CookieName, value, 0
+	if ttl > 0 {
+		c.MaxAge = int((ttl + time.Second - 1) / time.Second)
+	} else if ttl < 0 {
+		c.MaxAge = -1
+	}
+	return httpd.SetCookie(w, &c)
+}
+
+//32字节的随机数，无法被猜测
+func newID() string {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic("sessions: crypto/rand failed: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//Session是一次请求中的会话，可以在多个goroutine中使用
+type Session struct {
+	mu  sync.Mutex
+	mgr *Manager
+	w   httpd.ResponseWriter
+	rec *Record
+	//cookie中的令牌，新建的会话为空
+	token string
+	//RegenerateID之前的令牌，保存时从Store中删除
+	oldToken string
+
+	isNew     bool
+	modified  bool
+	destroyed bool
+	saved     bool
+	saveErr   error
+}
+
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rec.ID
+}
+
+//IsNew表示会话是本次请求新建的，而不是从cookie中加载的
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+func (s *Session) CreatedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rec.Created
+}
+
+func (s *Session) Get(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rec.Values[key]
+}
+
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec.Values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rec.Values, key)
+	s.modified = true
+}
+
+//Clear删除会话中的所有数据，但保留会话本身
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec.Values = make(map[string]interface{})
+	s.rec.Flashes = nil
+	s.modified = true
+}
+
+//AddFlash添加一条只显示一次的消息，通常在重定向之前添加，在下一个请求中通过Flashes取出
+func (s *Session) AddFlash(v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec.Flashes = append(s.rec.Flashes, v)
+	s.modified = true
+}
+
+//Flashes返回并清空所有的flash消息
+func (s *Session) Flashes() []interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := s.rec.Flashes
+	if len(flashes) > 0 {
+		s.rec.Flashes = nil
+		s.modified = true
+	}
+	return flashes
+}
+
+//RegenerateID为会话更换新的ID，数据保持不变，旧ID在保存时失效。
+//登录、退出、提升权限等操作时应调用，防止攻击者利用事先植入的会话ID(会话固定攻击)
+func (s *Session) RegenerateID() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && s.oldToken == "" {
+		s.oldToken = s.token
+	}
+	s.rec.ID = newID()
+	s.modified = true
+}
+
+//Destroy删除会话并让客户端删除cookie
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+//Save保存会话并设置Set-Cookie首部。会话在handler第一次写响应时会自动保存，
+//需要处理保存失败的情况时可以在写响应之前显式调用。同一个请求中只有第一次调用生效
+func (s *Session) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.saved {
+		return s.saveErr
+	}
+	s.saved = true
+	s.saveErr = s.save()
+	return s.saveErr
+}
+
+func (s *Session) save() error {
+	m := s.mgr
+	if s.destroyed {
+		for _, token := range []string{s.oldToken, s.token} {
+			if token != "" {
+				if err := m.Store.Delete(token); err != nil {
+					return err
+				}
+			}
+		}
+		//新建的会话客户端还没有cookie
+		if s.token == "" {
+			return nil
+		}
+		return m.setCookie(s.w, "", -1)
+	}
+	if s.isNew && !s.modified {
+		return nil
+	}
+	if s.oldToken != "" {
+		if err := m.Store.Delete(s.oldToken); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	s.rec.LastAccess = now
+	ttl := m.ttl(s.rec, now)
+	token, err := m.Store.Save(s.rec, ttl)
+	if err != nil {
+		return err
+	}
+	return m.setCookie(s.w, token, ttl)
+}
+
+//会话必须在响应首部发出之前保存，因此拦截第一次WriteHeader或Write
+type sessionWriter struct {
+	httpd.ResponseWriter
+	s           *Session
+	wroteHeader bool
+}
+
+func (sw *sessionWriter) WriteHeader(statusCode int) {
+	if !sw.wroteHeader {
+		sw.wroteHeader = true
+		if err := sw.s.Save(); err != nil {
+			log.Printf("sessions: save session: %v", err)
+		}
+	}
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sw *sessionWriter) Write(p []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(httpd.StatusOK)
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *sessionWriter) Unwrap() httpd.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func (sw *sessionWriter) Flush() {
+	if !sw.wroteHeader {
+		sw.WriteHeader(httpd.StatusOK)
+	}
+	if f, ok := sw.ResponseWriter.(httpd.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,304 @@
+package sessions
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dbldqt/httpImp/httpd"
+	"github.com/dbldqt/httpImp/httpd/securecookie"
+)
+
+type recorder struct {
+	header httpd.Header
+	code   int
+}
+
+func (r *recorder) Header() httpd.Header        { return r.header }
+func (r *recorder) Write(p []byte) (int, error) { return len(p), nil }
+func (r *recorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+//以token作为会话cookie发送一个请求，fn在handler中操作会话
+func serve(m *Manager, token string, fn func(s *Session)) *recorder {
+	r := &httpd.Request{Method: "GET", Header: httpd.Header{}}
+	if token != "" {
+		r.Header.Set("Cookie", m.CookieName+"="+token)
+	}
+	w := &recorder{header: httpd.Header{}}
+	m.Middleware(httpd.HandlerFunc(func(w httpd.ResponseWriter, r *httpd.Request) {
+		fn(Get(r))
+		w.Write([]byte("ok"))
+	})).ServeHTTP(w, r)
+	return w
+}
+
+//响应中下发的会话cookie，没有时返回nil
+func sessionCookie(t *testing.T, m *Manager, w *recorder) *http.Cookie {
+	t.Helper()
+	resp := &http.Response{Header: http.Header{"Set-Cookie": w.header["Set-Cookie"]}}
+	for _, c := range resp.Cookies() {
+		if c.Name == m.CookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+//新建一个会话并返回它的令牌
+func login(t *testing.T, m *Manager) string {
+	t.Helper()
+	c := sessionCookie(t, m, serve(m, "", func(s *Session) { s.Set("user", "bob") }))
+	if c == nil {
+		t.Fatal("no session cookie")
+	}
+	return c.Value
+}
+
+func TestNewSessionNotSaved(t *testing.T) {
+	m := NewManager(NewMemoryStore())
+	w := serve(m, "", func(s *Session) {
+		if !s.IsNew() {
+			t.Error("IsNew = false")
+		}
+	})
+	if c := sessionCookie(t, m, w); c != nil {
+		t.Errorf("unmodified new session set cookie %v", c)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	ms := NewMemoryStore()
+	m := NewManager(ms)
+	token := login(t, m)
+	serve(m, token, func(s *Session) {
+		if s.IsNew() || s.Get("user") != "bob" {
+			t.Errorf("session not loaded: new %v, user %v", s.IsNew(), s.Get("user"))
+		}
+	})
+
+	//超过IdleTimeout未访问，即使Store中的记录还在也不再有效
+	e := ms.m[token]
+	e.rec.LastAccess = time.Now().Add(-m.IdleTimeout - time.Second)
+	e.expires = time.Now().Add(time.Hour)
+	ms.m[token] = e
+	serve(m, token, func(s *Session) {
+		if !s.IsNew() || s.Get("user") != nil {
+			t.Error("idle session still loaded")
+		}
+	})
+	if _, err := ms.Load(token); err != ErrNotFound {
+		t.Errorf("idle session not deleted: %v", err)
+	}
+}
+
+func TestAbsoluteTimeout(t *testing.T) {
+	ms := NewMemoryStore()
+	m := NewManager(ms)
+	token := login(t, m)
+
+	//每次访问都会顺延空闲超时，但不会顺延绝对超时
+	e := ms.m[token]
+	e.rec.Created = time.Now().Add(-m.AbsoluteTimeout + time.Minute)
+	ms.m[token] = e
+	c := sessionCookie(t, m, serve(m, token, func(s *Session) { s.Set("n", 1) }))
+	if c == nil || c.MaxAge <= 0 || c.MaxAge > 60 {
+		t.Fatalf("cookie %v, want Max-Age limited by the absolute timeout", c)
+	}
+	e = ms.m[token]
+	if remain := time.Until(e.expires); remain <= 0 || remain > time.Minute {
+		t.Errorf("store expiry in %v, want at most 1m", remain)
+	}
+
+	e.rec.Created = time.Now().Add(-m.AbsoluteTimeout - time.Second)
+	ms.m[token] = e
+	serve(m, token, func(s *Session) {
+		if !s.IsNew() {
+			t.Error("session past the absolute timeout still loaded")
+		}
+	})
+}
+
+func TestStoreExpiry(t *testing.T) {
+	ms := NewMemoryStore()
+	m := NewManager(ms)
+	token := login(t, m)
+	e := ms.m[token]
+	e.expires = time.Now().Add(-time.Second)
+	ms.m[token] = e
+	if _, err := ms.Load(token); err != ErrNotFound {
+		t.Errorf("expired record loaded: %v", err)
+	}
+}
+
+//IdleTimeout和AbsoluteTimeout都为0时会话不过期，cookie为会话cookie
+func TestNoTimeout(t *testing.T) {
+	stores := map[string]Store{
+		"memory": NewMemoryStore(),
+		"file":   &FileStore{Dir: t.TempDir()},
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			m := NewManager(store)
+			m.IdleTimeout, m.AbsoluteTimeout = 0, 0
+			w := serve(m, "", func(s *Session) { s.Set("user", "bob") })
+			c := sessionCookie(t, m, w)
+			if c == nil {
+				t.Fatal("no session cookie")
+			}
+			if c.MaxAge != 0 || strings.Contains(w.header.Get("Set-Cookie"), "Max-Age") {
+				t.Errorf("Set-Cookie %q, want no Max-Age", w.header.Get("Set-Cookie"))
+			}
+			serve(m, c.Value, func(s *Session) {
+				if s.IsNew() || s.Get("user") != "bob" {
+					t.Error("session lost on the next request")
+				}
+			})
+			if fs, ok := store.(*FileStore); ok {
+				if err := fs.Sweep(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := store.Load(c.Value); err != nil {
+				t.Errorf("Load after sweep: %v", err)
+			}
+		})
+	}
+}
+
+func TestRegenerateID(t *testing.T) {
+	ms := NewMemoryStore()
+	m := NewManager(ms)
+	old := login(t, m)
+	c := sessionCookie(t, m, serve(m, old, func(s *Session) { s.RegenerateID() }))
+	if c == nil || c.Value == old {
+		t.Fatalf("cookie %v, want a new token", c)
+	}
+	if _, err := ms.Load(old); err != ErrNotFound {
+		t.Errorf("old token still valid: %v", err)
+	}
+	rec, err := ms.Load(c.Value)
+	if err != nil || rec.Values["user"] != "bob" {
+		t.Errorf("new token: %v, %v", rec, err)
+	}
+	serve(m, old, func(s *Session) {
+		if !s.IsNew() {
+			t.Error("old token loaded a session")
+		}
+	})
+}
+
+func TestDestroy(t *testing.T) {
+	ms := NewMemoryStore()
+	m := NewManager(ms)
+	token := login(t, m)
+	w := serve(m, token, func(s *Session) { s.Destroy() })
+	c := sessionCookie(t, m, w)
+	if c == nil || c.MaxAge >= 0 {
+		t.Errorf("Set-Cookie %q, want the cookie deleted", w.header.Get("Set-Cookie"))
+	}
+	if _, err := ms.Load(token); err != ErrNotFound {
+		t.Errorf("destroyed session still stored: %v", err)
+	}
+
+	//新建的会话被销毁时客户端还没有cookie，不需要删除
+	w = serve(m, "", func(s *Session) {
+		s.Set("a", 1)
+		s.Destroy()
+	})
+	if c = sessionCookie(t, m, w); c != nil {
+		t.Errorf("destroyed new session set cookie %v", c)
+	}
+}
+
+func TestFlashes(t *testing.T) {
+	m := NewManager(NewMemoryStore())
+	token := login(t, m)
+	serve(m, token, func(s *Session) {
+		s.AddFlash("saved")
+		s.AddFlash("again")
+	})
+	serve(m, token, func(s *Session) {
+		if got := s.Flashes(); !reflect.DeepEqual(got, []interface{}{"saved", "again"}) {
+			t.Errorf("Flashes = %v", got)
+		}
+		//同一个请求中再取已经为空
+		if got := s.Flashes(); got != nil {
+			t.Errorf("second Flashes = %v", got)
+		}
+	})
+	serve(m, token, func(s *Session) {
+		if got := s.Flashes(); got != nil {
+			t.Errorf("Flashes on the next request = %v", got)
+		}
+	})
+}
+
+func TestFileStoreToken(t *testing.T) {
+	dir := t.TempDir()
+	fs := &FileStore{Dir: dir}
+	for _, token := range []string{"", "../x", "a/b", `a\b`, "a.b", "sess_..", "a\x00b"} {
+		if _, err := fs.path(token); err != errInvalidToken {
+			t.Errorf("path(%q) err = %v", token, err)
+		}
+		if _, err := fs.Load(token); err != ErrNotFound {
+			t.Errorf("Load(%q) err = %v", token, err)
+		}
+		if _, err := fs.Save(&Record{ID: token}, time.Minute); err == nil {
+			t.Errorf("Save(%q) succeeded", token)
+		}
+	}
+
+	//filepath.Join(dir, "sess_../victim")会得到dir/victim，不能被删除
+	victim := filepath.Join(dir, "victim")
+	if err := os.WriteFile(victim, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs.Delete("../victim")
+	if _, err := os.Stat(victim); err != nil {
+		t.Errorf("Delete escaped the session file name: %v", err)
+	}
+
+	m := NewManager(fs)
+	token := login(t, m)
+	if _, err := os.Stat(filepath.Join(dir, "sess_"+token)); err != nil {
+		t.Errorf("session file: %v", err)
+	}
+	serve(m, token, func(s *Session) {
+		if s.Get("user") != "bob" {
+			t.Errorf("user = %v", s.Get("user"))
+		}
+	})
+}
+
+func TestCookieStore(t *testing.T) {
+	codec, err := securecookie.New(securecookie.Key{Hash: securecookie.GenerateKey(64), Block: securecookie.GenerateKey(32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(NewCookieStore(codec))
+	token := login(t, m)
+	if strings.Contains(token, "bob") {
+		t.Errorf("cookie %q is not encrypted", token)
+	}
+	serve(m, token, func(s *Session) {
+		if s.IsNew() || s.Get("user") != "bob" {
+			t.Errorf("session not restored from cookie: new %v, user %v", s.IsNew(), s.Get("user"))
+		}
+	})
+
+	tampered := []byte(token)
+	tampered[len(tampered)/2] ^= 1
+	serve(m, string(tampered), func(s *Session) {
+		if !s.IsNew() {
+			t.Error("tampered cookie accepted")
+		}
+	})
+}
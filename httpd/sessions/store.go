@@ -0,0 +1,220 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dbldqt/httpImp/httpd/securecookie"
+)
+
+//MemoryStore将会话保存在进程内存中，进程重启后会话全部失效，也不能在多个进程间共享
+type MemoryStore struct {
+	mu        sync.Mutex
+	m         map[string]memEntry
+	nextSweep time.Time
+}
+
+type memEntry struct {
+	rec     Record
+	expires time.Time //零值表示不过期
+}
+
+//ttl<=0表示会话不过期(Manager的两个超时都为0)，过期时间记为零值
+func expiresAt(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+func isExpired(expires, now time.Time) bool {
+	return !expires.IsZero() && now.After(expires)
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{m: make(map[string]memEntry)}
+}
+
+func (ms *MemoryStore) Load(token string) (*Record, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	e, ok := ms.m[token]
+	if !ok || isExpired(e.expires, time.Now()) {
+		return nil, ErrNotFound
+	}
+	return copyRecord(&e.rec), nil
+}
+
+func (ms *MemoryStore) Save(rec *Record, ttl time.Duration) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := time.Now()
+	//过期的会话只有在Load时才会被发现，定期清理一次，避免不再访问的会话一直占用内存
+	if now.After(ms.nextSweep) {
+		for id, e := range ms.m {
+			if isExpired(e.expires, now) {
+				delete(ms.m, id)
+			}
+		}
+		ms.nextSweep = now.Add(time.Minute)
+	}
+	ms.m[rec.ID] = memEntry{rec: *copyRecord(rec), expires: expiresAt(now, ttl)}
+	return rec.ID, nil
+}
+
+func (ms *MemoryStore) Delete(token string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.m, token)
+	return nil
+}
+
+//同一个会话可能被并发的请求同时使用，存取时都要拷贝，不能共享Values
+func copyRecord(rec *Record) *Record {
+	c := *rec
+	c.Values = make(map[string]interface{}, len(rec.Values))
+	for k, v := range rec.Values {
+		c.Values[k] = v
+	}
+	c.Flashes = append([]interface{}(nil), rec.Flashes...)
+	return &c
+}
+
+//FileStore将每个会话以JSON保存在Dir下的一个文件中，文件名为sess_加会话ID
+type FileStore struct {
+	Dir string
+}
+
+type fileRecord struct {
+	Expires time.Time `json:"expires"` //零值表示不过期
+	Record  *Record   `json:"record"`
+}
+
+var errInvalidToken = errors.New("sessions: invalid token")
+
+//令牌来自客户端，只允许newID生成的字符，防止路径穿越
+func (fs *FileStore) path(token string) (string, error) {
+	if token == "" || strings.IndexFunc(token, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_')
+	}) != -1 {
+		return "", errInvalidToken
+	}
+	return filepath.Join(fs.Dir, "sess_"+token), nil
+}
+
+func (fs *FileStore) Load(token string) (*Record, error) {
+	path, err := fs.path(token)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var fr fileRecord
+	if err = json.Unmarshal(data, &fr); err != nil || fr.Record == nil {
+		return nil, ErrNotFound
+	}
+	if isExpired(fr.Expires, time.Now()) {
+		os.Remove(path)
+		return nil, ErrNotFound
+	}
+	return fr.Record, nil
+}
+
+func (fs *FileStore) Save(rec *Record, ttl time.Duration) (string, error) {
+	path, err := fs.path(rec.ID)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(fileRecord{Expires: expiresAt(time.Now(), ttl), Record: rec})
+	if err != nil {
+		return "", err
+	}
+	//先写入临时文件再重命名，并发的请求不会读到写了一半的文件
+	file, err := os.CreateTemp(fs.Dir, ".sess-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(data)
+	if cerr := file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return rec.ID, nil
+}
+
+func (fs *FileStore) Delete(token string) error {
+	path, err := fs.path(token)
+	if err != nil {
+		return nil
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//Sweep删除Dir下所有已过期的会话文件，需要使用方定期调用
+func (fs *FileStore) Sweep() error {
+	paths, err := filepath.Glob(filepath.Join(fs.Dir, "sess_*"))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var fr fileRecord
+		if json.Unmarshal(data, &fr) != nil || isExpired(fr.Expires, now) {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+//CookieStore不在服务端保存任何数据，整个会话经Codec签名(及加密)后保存在cookie中。
+//会话数据受cookie大小限制，且Delete无法让已经下发的cookie失效，RegenerateID后旧cookie在过期前仍然有效，
+//对此敏感的场景应使用服务端存储
+type CookieStore struct {
+	Codec *securecookie.Codec
+}
+
+func NewCookieStore(codec *securecookie.Codec) *CookieStore {
+	return &CookieStore{Codec: codec}
+}
+
+//签名时绑定的名称，与Manager.CookieName无关
+const cookieStoreName = "sessions"
+
+func (cs *CookieStore) Load(token string) (*Record, error) {
+	var rec Record
+	if err := cs.Codec.Decode(cookieStoreName, token, &rec); err != nil {
+		return nil, ErrNotFound
+	}
+	return &rec, nil
+}
+
+func (cs *CookieStore) Save(rec *Record, ttl time.Duration) (string, error) {
+	return cs.Codec.Encode(cookieStoreName, rec)
+}
+
+func (cs *CookieStore) Delete(token string) error {
+	return nil
+}
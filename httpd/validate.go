@@ -0,0 +1,300 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+//单个字段未通过校验的原因，字段名优先使用json、form、query、path、header标签中的名称
+type ValidationError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+//Validate返回的错误，包含所有未通过校验的字段
+type ValidationErrors []*ValidationError
+
+func (es ValidationErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+//自定义校验规则，v为字段的值(指针已解引用)，param为规则=后面的参数，返回false表示未通过
+type ValidatorFunc func(v reflect.Value, param string) bool
+
+var (
+	validatorMu sync.RWMutex
+	validators  = make(map[string]ValidatorFunc)
+	regexpCache sync.Map //规则中的正则表达式编译后缓存起来，map[string]*regexp.Regexp
+)
+
+//RegisterValidator注册自定义校验规则，之后可以在validate标签中使用，如`validate:"required,phone"`
+func RegisterValidator(name string, fn ValidatorFunc) {
+	validatorMu.Lock()
+	defer validatorMu.Unlock()
+	validators[name] = fn
+}
+
+/**
+Validate根据validate标签校验结构体，通常在Bind之后调用：
+	type SignupReq struct {
+		Email string   `json:"email" validate:"required,email"`
+		Age   int      `json:"age" validate:"min=18,max=130"`
+		Code  string   `json:"code" validate:"omitempty,len=6,regexp=^[0-9]+$"`
+		Plan  string   `json:"plan" validate:"oneof=free pro"`
+		Tags  []string `json:"tags" validate:"max=5"`
+	}
+多个规则以逗号分隔。regexp的参数中可能含有逗号，因此它必须是最后一条规则。
+min、max、len对数字比较数值，对字符串比较字符数，对切片和map比较元素个数。
+规则对零值同样生效，如Age为0时min=18不通过；零值表示"未提供"的字段可以标注omitempty，为零值时跳过其余规则。
+nil指针表示未提供，除required外的规则都会跳过。嵌套的结构体会递归校验。
+ */
+func Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return errors.New("httpd: Validate called with nil pointer")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return errors.New("httpd: Validate requires a struct")
+	}
+	var errs ValidationErrors
+	validateStruct(rv, "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateStruct(v reflect.Value, prefix string, errs *ValidationErrors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue
+		}
+		fv := v.Field(i)
+		name := prefix + fieldName(sf)
+		if sf.Anonymous {
+			name = strings.TrimSuffix(prefix, ".")
+		}
+
+		if tag := sf.Tag.Get("validate"); tag != "" && tag != "-" {
+			validateField(fv, name, tag, errs)
+		}
+
+		//递归校验嵌套的结构体
+		for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct && fv.Type() != timeType {
+			if sf.Anonymous {
+				validateStruct(fv, prefix, errs)
+			} else {
+				validateStruct(fv, name+".", errs)
+			}
+		}
+	}
+}
+
+func fieldName(sf reflect.StructField) string {
+	for _, key := range []string{"json", "form", "query", "path", "header"} {
+		if name := strings.Split(sf.Tag.Get(key), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return sf.Name
+}
+
+type rule struct {
+	name  string
+	param string
+}
+
+func parseRules(tag string) []rule {
+	var rules []rule
+	for tag != "" {
+		var item string
+		//regexp的参数原样保留到标签末尾
+		if strings.HasPrefix(tag, "regexp=") {
+			item, tag = tag, ""
+		} else if i := strings.IndexByte(tag, ','); i != -1 {
+			item, tag = tag[:i], tag[i+1:]
+		} else {
+			item, tag = tag, ""
+		}
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		r := rule{name: item}
+		if i := strings.IndexByte(item, '='); i != -1 {
+			r.name, r.param = item[:i], item[i+1:]
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func validateField(fv reflect.Value, name, tag string, errs *ValidationErrors) {
+	rules := parseRules(tag)
+	zero := fv.IsZero()
+	for _, r := range rules {
+		switch r.name {
+		case "required":
+			if zero {
+				*errs = append(*errs, &ValidationError{Field: name, Rule: r.name, Message: "is required"})
+				return
+			}
+		case "omitempty":
+			if zero {
+				return
+			}
+		}
+	}
+	for fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return
+		}
+		fv = fv.Elem()
+	}
+	for _, r := range rules {
+		if r.name == "required" || r.name == "omitempty" {
+			continue
+		}
+		if msg, ok := checkRule(fv, r); !ok {
+			*errs = append(*errs, &ValidationError{Field: name, Rule: r.name, Param: r.param, Message: msg})
+		}
+	}
+}
+
+//字段的"大小"：数字为数值，字符串为字符数，切片、数组和map为元素个数
+func sizeOf(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true
+	}
+	return 0, false
+}
+
+func checkRule(v reflect.Value, r rule) (msg string, ok bool) {
+	switch r.name {
+	case "min", "max", "len":
+		limit, err := strconv.ParseFloat(r.param, 64)
+		size, sizable := sizeOf(v)
+		if err != nil || !sizable {
+			return "has invalid rule " + r.name + "=" + r.param, false
+		}
+		unit := ""
+		switch v.Kind() {
+		case reflect.String:
+			unit = " characters"
+		case reflect.Slice, reflect.Array, reflect.Map:
+			unit = " items"
+		}
+		switch r.name {
+		case "min":
+			return "must be at least " + r.param + unit, size >= limit
+		case "max":
+			return "must be at most " + r.param + unit, size <= limit
+		default:
+			if unit == "" {
+				return "must equal " + r.param, size == limit
+			}
+			return "must be exactly " + r.param + unit, size == limit
+		}
+	case "email":
+		if v.Kind() != reflect.String {
+			return "must be a string", false
+		}
+		//只接受裸地址，不接受"Bob <bob@example.com>"这种带显示名的形式
+		addr, err := mail.ParseAddress(v.String())
+		return "must be a valid email address", err == nil && addr.Address == v.String()
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		for _, opt := range strings.Fields(r.param) {
+			if s == opt {
+				return "", true
+			}
+		}
+		return "must be one of [" + r.param + "]", false
+	case "regexp":
+		if v.Kind() != reflect.String {
+			return "must be a string", false
+		}
+		re, err := compileRegexp(r.param)
+		if err != nil {
+			return "has invalid rule regexp=" + r.param, false
+		}
+		return "must match " + r.param, re.MatchString(v.String())
+	}
+
+	validatorMu.RLock()
+	fn, exist := validators[r.name]
+	validatorMu.RUnlock()
+	if !exist {
+		return "has unknown rule " + r.name, false
+	}
+	return "failed " + r.name + " validation", fn(v, r.param)
+}
+
+func compileRegexp(expr string) (*regexp.Regexp, error) {
+	if re, ok := regexpCache.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexpCache.Store(expr, re)
+	return re, nil
+}
+
+//WriteValidationError将Validate返回的错误以422 Unprocessable Entity回复：
+//	{"errors":[{"field":"email","rule":"email","message":"must be a valid email address"}]}
+//err不是ValidationErrors时(如Bind失败)回复400，body中只有一条message
+func WriteValidationError(w ResponseWriter, err error) {
+	var body struct {
+		Errors  []*ValidationError `json:"errors,omitempty"`
+		Message string             `json:"message,omitempty"`
+	}
+	status := StatusBadRequest
+	var ves ValidationErrors
+	if errors.As(err, &ves) {
+		status = StatusUnprocessableEntity
+		body.Errors = ves
+	} else {
+		body.Message = err.Error()
+	}
+	data, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(data)
+}
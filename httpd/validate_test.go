@@ -0,0 +1,61 @@
+package httpd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateZeroValues(t *testing.T) {
+	type signup struct {
+		Age      int      `json:"age" validate:"min=18"`
+		Plan     string   `json:"plan" validate:"oneof=free pro"`
+		Code     string   `json:"code" validate:"omitempty,len=6"`
+		Nickname *string  `json:"nickname" validate:"min=2"`
+		Email    string   `json:"email" validate:"required,email"`
+		Tags     []string `json:"tags" validate:"max=2"`
+	}
+	short := "a"
+	tests := []struct {
+		name  string
+		in    signup
+		rules map[string]string //未通过的字段及规则
+	}{
+		{
+			name:  "zero values are checked",
+			in:    signup{},
+			rules: map[string]string{"age": "min", "plan": "oneof", "email": "required"},
+		},
+		{
+			name:  "valid",
+			in:    signup{Age: 18, Plan: "free", Email: "a@example.com"},
+			rules: map[string]string{},
+		},
+		{
+			name:  "omitempty only skips zero values",
+			in:    signup{Age: 18, Plan: "pro", Code: "123", Nickname: &short, Email: "a@example.com", Tags: []string{"a", "b", "c"}},
+			rules: map[string]string{"code": "len", "nickname": "min", "tags": "max"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(&tt.in)
+			got := map[string]string{}
+			var ves ValidationErrors
+			if errors.As(err, &ves) {
+				for _, ve := range ves {
+					got[ve.Field] = ve.Rule
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.rules) {
+				t.Fatalf("failed rules = %v, want %v", got, tt.rules)
+			}
+			for field, rule := range tt.rules {
+				if got[field] != rule {
+					t.Errorf("%s: rule %q, want %q", field, got[field], rule)
+				}
+			}
+		})
+	}
+}
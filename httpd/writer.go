@@ -30,6 +30,10 @@ func (cw *chunkWriter) Write(p []byte) (n int,err error){
 		}
 		cw.wrote = true
 	}
+	//HEAD请求的响应只有首部，handler写入的body直接丢弃
+	if cw.resp.req.Method == "HEAD" {
+		return len(p),nil
+	}
 	bufw := cw.resp.c.bufw
 	//当Writes数据超过缓存容量时，利用chunk编码传输
 	if cw.resp.chunking {
@@ -48,6 +52,12 @@ func (cw *chunkWriter) Write(p []byte) (n int,err error){
 //设置响应头
 func (cw *chunkWriter) finalizeHeader(p []byte) {
 	header := cw.resp.header
+	//304、204等响应没有body，不需要Content-Type和任何表示body边界的首部，结尾也不能有0\r\n\r\n
+	if !bodyAllowedForStatus(cw.resp.statusCode) {
+		return
+	}
+	//HEAD请求的响应同样没有body，但Content-Length可以告知GET时body的长度
+	head := cw.resp.req.Method == "HEAD"
 	//如果用户未指定Content-Type,我们使用嗅探。此处直接使用标准库api
 	if header.Get("Content-Type") == "" {
 		header.Set("Content-Type",http.DetectContentType(p))
@@ -59,7 +69,7 @@ func (cw *chunkWriter) finalizeHeader(p []byte) {
 		if cw.resp.handlerDone {
 			buffered := cw.resp.bufw.Buffered()
 			header.Set("Content-Length",strconv.Itoa(buffered))
-		} else {
+		} else if !head {
 			//因为超出缓存触发Write
 			cw.resp.chunking = true
 			header.Set("Transfer-Encoding","chunked")
@@ -67,7 +77,7 @@ func (cw *chunkWriter) finalizeHeader(p []byte) {
 		return
 	}
 
-	if header.Get("Transfer-Encoding") == "chunked" {
+	if header.Get("Transfer-Encoding") == "chunked" && !head {
 		cw.resp.chunking = true
 	}
 }
@@ -83,10 +93,17 @@ func (cw *chunkWriter) writeHeader() (err error) {
 		return
 	}
 
-	for key,value := range cw.resp.header {
-		_,err = bufw.WriteString(key + ": " +value[0] + "\r\n")
-		if err != nil {
-			return
+	//告知客户端本次响应结束后连接将被关闭
+	if cw.resp.closeAfterReply && cw.resp.header.Get("Connection") == "" {
+		cw.resp.header.Set("Connection","close")
+	}
+	//同一个首部可能有多个值，如多个Set-Cookie，每个值单独占一行
+	for key,values := range cw.resp.header {
+		for _,value := range values {
+			_,err = bufw.WriteString(key + ": " + value + "\r\n")
+			if err != nil {
+				return
+			}
 		}
 	}
 	_,err = bufw.WriteString("\r\n")
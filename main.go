@@ -1,32 +1,17 @@
 package main
 
 import (
-	"fmt"
 	"github.com/dbldqt/httpImp/httpd"
-	"io"
-	"io/ioutil"
-	"os"
 )
 
 type myHandler struct{}
 
 func (*myHandler) ServeHTTP(w httpd.ResponseWriter, r *httpd.Request) {
 	if r.Url.Path == "/photo"{
-		file,err:=os.Open("./test.jpg")
-		if err!=nil{
-			fmt.Println("open file error:",err)
-			return
-		}
-		io.Copy(w,file)
-		file.Close()
+		httpd.ServeFile(w,r,"./test.jpg")
 		return
 	}
-	data,err:=ioutil.ReadFile("./test.html")
-	if err!=nil{
-		fmt.Println("readFile test.html error: ",err)
-		return
-	}
-	w.Write(data)
+	httpd.ServeFile(w,r,"./test.html")
 }
 
 func main() {
@@ -35,4 +20,4 @@ func main() {
 		Handler: new(myHandler),
 	}
 	panic(svr.ListenAndServe())
-}
\ No newline at end of file
+}